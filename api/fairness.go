@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/tinx/proto-artbattle/pairing"
+)
+
+type FairnessDTO struct {
+	Categories	[]FairnessCategoryDTO `json:"categories"`
+}
+
+type FairnessCategoryDTO struct {
+	/* "" if artworks are not split into categories */
+	Category	string `json:"category"`
+	Artworks	int `json:"artworks"`
+	MinDuels	uint64 `json:"min_duels"`
+	MaxDuels	uint64 `json:"max_duels"`
+	MeanDuels	float64 `json:"mean_duels"`
+	StdDev		float64 `json:"std_dev"`
+	Spread		uint64 `json:"spread"`
+	CatchingUp	int `json:"catching_up"`
+}
+
+/* Fairness serves GET /api/fairness: how evenly the duels are spread
+   over the artworks of each category. */
+func Fairness(db Repository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		all, err := db.GetAllArtworks()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading artworks: %s\n", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		dto := FairnessDTO{Categories: []FairnessCategoryDTO{}}
+		for _, s := range pairing.Exposure(all) {
+			dto.Categories = append(dto.Categories, FairnessCategoryDTO(s))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(&dto)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing fairness: %s\n", err)
+		}
+	})
+}
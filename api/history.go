@@ -0,0 +1,120 @@
+/* Package api has the read-only JSON endpoints for artists and
+   organizers. */
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tinx/proto-artbattle/database"
+	"gorm.io/gorm"
+)
+
+/* Repository is everything the endpoints need from the database. */
+type Repository interface {
+	GetArtworkById(id int64) (*database.Artwork, error)
+	GetAllArtworks() ([]*database.Artwork, error)
+	GetDuelsOfArtwork(id uint) ([]*database.Duel, error)
+}
+
+type HistoryDTO struct {
+	ID		uint `json:"id"`
+	Title		string `json:"title"`
+	Artist		string `json:"artist"`
+	EloRating	float64 `json:"elo_rating"`
+	DuelCount	uint64 `json:"duel_count"`
+	/* duels from before ratings were kept with each duel */
+	Untracked	int `json:"untracked_duels"`
+	History		[]HistoryEntryDTO `json:"history"`
+}
+
+type HistoryEntryDTO struct {
+	When		time.Time `json:"when"`
+	DuelID		uint `json:"duel_id"`
+	OpponentID	uint `json:"opponent_id"`
+	/* won, lost, draw or timeout */
+	Result		string `json:"result"`
+	RatingBefore	float64 `json:"rating_before"`
+	RatingAfter	float64 `json:"rating_after"`
+	RankBefore	int64 `json:"rank_before"`
+	RankAfter	int64 `json:"rank_after"`
+}
+
+/* History serves GET .../{id}/history: the rating and rank of an artwork
+   after each of its duels, oldest first. */
+func History(db Repository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "malformed artwork id", http.StatusBadRequest)
+			return
+		}
+		a, err := db.GetArtworkById(int64(id))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "no such artwork", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading artwork %d: %s\n", id, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		duels, err := db.GetDuelsOfArtwork(a.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading duels of artwork %d: %s\n", id, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		dto := HistoryDTO{
+			ID: a.ID,
+			Title: a.Title,
+			Artist: a.Artist,
+			EloRating: a.EloRating,
+			DuelCount: a.DuelCount,
+			History: []HistoryEntryDTO{},
+		}
+		for _, d := range duels {
+			if d.Rank1After == 0 {
+				dto.Untracked = dto.Untracked + 1
+				continue
+			}
+			dto.History = append(dto.History, encodeHistoryEntry(a.ID, d))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(&dto)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing history: %s\n", err)
+		}
+	})
+}
+
+/* encodeHistoryEntry describes a duel from the point of view of id */
+func encodeHistoryEntry(id uint, d *database.Duel) HistoryEntryDTO {
+	e := HistoryEntryDTO{When: d.When, DuelID: d.ID}
+	if d.Duelist1 == id {
+		e.OpponentID = d.Duelist2
+		e.RatingBefore, e.RatingAfter = d.Rating1Before, d.Rating1After
+		e.RankBefore, e.RankAfter = d.Rank1Before, d.Rank1After
+	} else {
+		e.OpponentID = d.Duelist1
+		e.RatingBefore, e.RatingAfter = d.Rating2Before, d.Rating2After
+		e.RankBefore, e.RankAfter = d.Rank2Before, d.Rank2After
+	}
+	if d.TimedOut {
+		e.Result = "timeout"
+	} else if d.Winner == 0 {
+		e.Result = "draw"
+	} else if d.Winner == id {
+		e.Result = "won"
+	} else {
+		e.Result = "lost"
+	}
+	return e
+}
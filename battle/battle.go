@@ -0,0 +1,518 @@
+package battle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/input"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+	"github.com/tinx/proto-artbattle/pairing"
+)
+
+/* Finite State Machine
+ *  Start -> Duel
+ *  Duel -> Timeout
+ *  Duel -> Decision
+ *  Decision -> Duel (showing DECISION or DRAW)
+ *  Timeout -> Leaderboard
+ *  Leaderboard -> SplashScreen
+ *  SplashScreen -> Duel
+ *  * -> Error
+ *  Error -> Duel
+ *
+ * With categories, duels are drawn from one category at a time, taking
+ * turns, and the leaderboard of each category is shown in turn.
+ *
+ * With pairing.prefetch, the next duels are paired in the background
+ * while a duel or decision is shown, and announced with PRELOAD.
+ *
+ * Finals, while finals.enabled is set
+ *  Duel -> Bracket (once there are at least 2 artworks to seed it)
+ *  Bracket -> Match (showing DUEL)
+ *  Match -> Match (showing MATCH_RESULT)
+ *  Match -> Bracket (on timeout, or when the match is decided)
+ *  Bracket -> Champion
+ *  Champion -> Bracket
+ *  Bracket, Match, Champion -> Duel (when finals.enabled is unset)
+ */
+type State string
+
+const (
+	StateStart		State = "Start"
+	StateDuel		State = "Duel"
+	StateTimeout		State = "Timeout"
+	StateDecision		State = "Decision"
+	StateLeaderboard	State = "Leaderboard"
+	StateSplashScreen	State = "SplashScreen"
+	StateError		State = "Error"
+	StateBracket		State = "Bracket"
+	StateMatch		State = "Match"
+	StateChampion		State = "Champion"
+)
+
+/* Repository is everything the state machine needs from the database. */
+type Repository interface {
+	pairing.Repository
+	GetLeaderboard(maxcount int, category string) ([]*database.Artwork, error)
+	GetTotalDuelCount() (int64, error)
+	GetArtworkById(id int64) (*database.Artwork, error)
+	Transaction(tx func(database.Tx) error) error
+	AddBracket(b *database.Bracket, matches []*database.Match) error
+	GetCurrentBracket() (*database.Bracket, error)
+	GetMatches(bracketId uint) ([]*database.Match, error)
+}
+
+/* Input delivers button input. Only the bytes '1' and '2' are
+   considered, and '3' if draws are enabled. Everything else is ignored. input.Mux satisfies this
+   interface. */
+type Input interface {
+	Votes() <-chan input.Vote
+}
+
+/* ChannelInput is the simplest Input: whatever is written to the
+   channel counts as button input. */
+type ChannelInput chan input.Vote
+
+func (c ChannelInput) Votes() <-chan input.Vote {
+	return c
+}
+
+/* Broadcaster sends a message to all connected displays.
+   melody.Melody satisfies this interface. */
+type Broadcaster interface {
+	Broadcast(msg []byte) error
+}
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+/* SystemClock is the wall clock. */
+var SystemClock Clock = systemClock{}
+
+type Battle struct {
+	repo		Repository
+	input		Input
+	out		Broadcaster
+	clock		Clock
+	rng		*rand.Rand
+
+	state		State
+	lastError	string
+	a1, a2		*database.Artwork
+	vote		string
+	/* the input source of the last vote, and when its first button was
+	   pressed */
+	voteSource	string
+	voteAt		time.Time
+	shown		presentation
+	/* duels paired ahead of time, and the ones shown before */
+	queue		queue
+	/* finals are enabled, but there are too few artworks, and that was
+	   logged already */
+	finalsWaiting	bool
+
+	/* what the displays are currently showing, for late joiners */
+	screenLock	sync.Mutex
+	lastMessages	map[string]string
+	screenType	string
+	screenState	State
+	screenUntil	time.Time
+	disconnected	map[string]string
+}
+
+func New(repo Repository, input Input, out Broadcaster, clock Clock) *Battle {
+	b := &Battle{
+		repo: repo,
+		input: input,
+		out: out,
+		clock: clock,
+		state: StateStart,
+		lastMessages: map[string]string{},
+		disconnected: map[string]string{},
+	}
+	b.queue.history = pairing.NewHistory()
+	b.SetRand(rand.New(rand.NewSource(clock.Now().UnixNano())))
+	return b
+}
+
+/* SetRand replaces the random source used for pairing, e.g. with a
+   seeded one for reproducible simulations. */
+func (b *Battle) SetRand(rng *rand.Rand) {
+	b.rng = rng
+	/* duels paired ahead of time draw from their own source */
+	b.queue.lock.Lock()
+	b.queue.rng = rand.New(rand.NewSource(rng.Int63()))
+	b.queue.lock.Unlock()
+}
+
+func (b *Battle) State() State {
+	return b.state
+}
+
+func (b *Battle) LastError() string {
+	return b.lastError
+}
+
+/* Run executes the state machine forever. */
+func (b *Battle) Run() {
+	for {
+		b.Step()
+	}
+}
+
+func (b *Battle) fail(format string, err error) {
+	b.state = StateError
+	b.lastError = fmt.Sprintf(format, err)
+}
+
+/* Step performs the work of the current state, including waiting for
+   input or timeouts, and moves on to the next state. */
+func (b *Battle) Step() {
+	switch b.state {
+	case StateStart:
+		/* the cooldowns carry over restarts */
+		err := b.repo.Transaction(func(tx database.Tx) error {
+			duels, err := tx.GetAllDuels()
+			if err != nil {
+				return err
+			}
+			b.queue.history.Seed(duels)
+			return nil
+		})
+		if err != nil {
+			b.fail("Start error: %s", err)
+			return
+		}
+		b.state = StateDuel
+	case StateDuel:
+		if config.FinalsEnabled() {
+			ready, err := b.finalsReady()
+			if err != nil {
+				b.fail("Finals error: %s", err)
+				return
+			}
+			if ready {
+				b.state = StateBracket
+				return
+			}
+		}
+		a1, a2, err := b.next()
+		if err != nil {
+			b.fail("Duel error: %s", err)
+			return
+		}
+		b.a1, b.a2 = a1, a2
+		b.prefetch()
+		timeout := config.TimingsDuelTimeout() * time.Second
+		json, err := encodeDuelToJson(b.a1, b.a2, timeout)
+		if err != nil {
+			b.fail("Duel error: %s", err)
+			return
+		}
+		shownAt := b.clock.Now()
+		b.vote = b.show("DUEL", json, timeout)
+		endedAt := b.clock.Now()
+		if b.vote != "" {
+			/* not counting the wait for a draw */
+			endedAt = b.voteAt
+		}
+		b.shown = presentation{
+			shownAt: shownAt,
+			endedAt: endedAt,
+			source: b.voteSource,
+		}
+		if b.vote == "" {
+			b.state = StateTimeout
+		} else {
+			b.state = StateDecision
+		}
+	case StateTimeout:
+		err := recordTimeout(b.repo, b.a1, b.a2, b.shown)
+		if err != nil {
+			b.fail("Timeout error: %s", err)
+			return
+		}
+		json, err := encodeDuelToJson(b.a1, b.a2, 0)
+		if err != nil {
+			b.fail("Timeout error: %s", err)
+			return
+		}
+		b.show("TIMEOUT", json, 2 * time.Second)
+		b.state = StateLeaderboard
+	case StateLeaderboard:
+		for _, category := range categories() {
+			json, err := getLeaderboard(b.repo, category)
+			if err != nil {
+				b.fail("Leaderboard error: %s", err)
+				return
+			}
+			if b.show("LEADERBOARD", json, config.TimingsLeaderboard() * time.Second) != "" {
+				break
+			}
+		}
+		b.state = StateSplashScreen
+	case StateSplashScreen:
+		json, err := getSplashScreen(b.repo)
+		if err != nil {
+			b.fail("Splash screen error: %s", err)
+			return
+		}
+		b.show("SPLASH", json, config.TimingsSplashScreen() * time.Second)
+		b.state = StateDuel
+	case StateDecision:
+		json, err := processDecision(b.repo, b.a1, b.a2, b.vote[0], b.shown)
+		if err != nil {
+			b.fail("Decision error: %s", err)
+			return
+		}
+		b.queue.rated(b.a1, b.a2)
+		b.prefetch()
+		if b.vote == "3" {
+			b.show("DRAW", json, 2 * time.Second)
+		} else {
+			b.show("DECISION", json, 2 * time.Second)
+		}
+		b.state = StateDuel
+	case StateBracket, StateMatch, StateChampion:
+		if !config.FinalsEnabled() {
+			b.state = StateDuel
+		} else if b.state == StateBracket {
+			b.stepBracket()
+		} else if b.state == StateMatch {
+			b.stepMatch()
+		} else {
+			b.stepChampion()
+		}
+	case StateError:
+		var dto ErrorDTO
+		dto.Message = b.lastError
+		j, err := json.Marshal(&dto)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding error message: %s\n", b.lastError)
+			b.waitForInput(30 * time.Second)
+		} else {
+			b.show("ERROR", string(j), 30 * time.Second)
+		}
+		b.state = StateDuel
+	default:
+		b.state = StateDuel
+	}
+}
+
+/* categories returns the configured categories, or just "" for all
+   artworks if there are none. */
+func categories() []string {
+	names := config.CategoryNames()
+	if len(names) == 0 {
+		return []string{""}
+	}
+	return names
+}
+
+/* rotation tells which category's turn it is. */
+type rotation struct {
+	/* index of the category whose turn it is, and its duels so far */
+	turn		int
+	duels		int
+}
+
+/* pair picks the next duel from the category whose turn it is, avoiding
+   what is in history. After categories.duels_per_turn duels, or when a
+   category has nothing left to pair, the next one takes over. */
+func pair(repo pairing.Repository, r *rotation, history *pairing.History, rng *rand.Rand) (*database.Artwork, *database.Artwork, error) {
+	names := categories()
+	next := func() {
+		r.turn = (r.turn + 1) % len(names)
+		r.duels = 0
+	}
+	if r.duels >= config.CategoryDuelsPerTurn() {
+		next()
+	}
+	strategy := pairing.FromConfiguration()
+	for range names {
+		category := names[r.turn % len(names)]
+		a1, a2, err := pairing.Pair(strategy, repo, rng, history.Exclusions(category))
+		if err == nil {
+			r.duels = r.duels + 1
+			return a1, a2, nil
+		}
+		if !errors.Is(err, pairing.ErrNoContenders) {
+			return nil, nil, err
+		}
+		next()
+	}
+	fmt.Fprintf(os.Stderr, "no contenders available\n")
+	return nil, nil, pairing.ErrNoContenders
+}
+
+/* show broadcasts a screen to all displays, remembers it for displays
+   connecting later and then waits for input for up to d. */
+func (b *Battle) show(msgType string, payload string, d time.Duration) string {
+	b.screenLock.Lock()
+	b.lastMessages[msgType] = payload
+	b.screenType = msgType
+	b.screenState = b.state
+	b.screenUntil = b.clock.Now().Add(d)
+	b.screenLock.Unlock()
+
+	b.out.Broadcast([]byte(msgType + ": " + payload))
+	return b.waitForInput(d)
+}
+
+/* Replay returns the messages a newly connected display needs to show
+   the current screen: the last broadcast of the current message type,
+   followed by a STATE message with the time left in that state. */
+func (b *Battle) Replay() [][]byte {
+	b.screenLock.Lock()
+	defer b.screenLock.Unlock()
+
+	if b.screenType == "" {
+		return nil
+	}
+	var dto StateDTO
+	dto.State = string(b.screenState)
+	dto.Screen = b.screenType
+	dto.RemainingMs = b.screenUntil.Sub(b.clock.Now()).Milliseconds()
+	if dto.RemainingMs < 0 {
+		dto.RemainingMs = 0
+	}
+	j, err := json.Marshal(&dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return nil
+	}
+	msgs := [][]byte{
+		[]byte(b.screenType + ": " + b.lastMessages[b.screenType]),
+	}
+	if len(b.disconnected) > 0 {
+		msgs = append(msgs, []byte("INPUT: " + b.lastMessages["INPUT"]))
+	}
+	return append(msgs, []byte("STATE: " + string(j)))
+}
+
+/* SetInputStatus records whether an input source works and tells the
+   displays, so they can show that the buttons are disconnected. */
+func (b *Battle) SetInputStatus(source string, connected bool, reason string) {
+	b.screenLock.Lock()
+	if connected {
+		delete(b.disconnected, source)
+	} else {
+		b.disconnected[source] = reason
+	}
+	var dto InputStatusDTO
+	dto.Connected = len(b.disconnected) == 0
+	dto.Disconnected = []string{}
+	for s, r := range b.disconnected {
+		dto.Disconnected = append(dto.Disconnected, s)
+		dto.Message = r
+	}
+	sort.Strings(dto.Disconnected)
+	j, err := json.Marshal(&dto)
+	if err != nil {
+		b.screenLock.Unlock()
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return
+	}
+	b.lastMessages["INPUT"] = string(j)
+	b.screenLock.Unlock()
+
+	b.out.Broadcast([]byte("INPUT: " + string(j)))
+}
+
+/* LastMessage returns the payload most recently broadcast with the given
+   message type, or "" if there was none yet. */
+func (b *Battle) LastMessage(msgType string) string {
+	b.screenLock.Lock()
+	defer b.screenLock.Unlock()
+	return b.lastMessages[msgType]
+}
+
+/* waitForInput returns the button pressed ("1", "2" or "3" for a draw),
+   or "" if the timeout expired first. The input source is kept in
+   voteSource. Only votes in a duel or match can be draws, elsewhere any
+   button just moves on right away. */
+func (b *Battle) waitForInput(timeout time.Duration) string {
+	c := b.input.Votes()
+	/* consume left-over data in the channel */
+	Loop:
+	for {
+		select {
+		case <-c:
+		default:
+			break Loop
+		}
+	}
+	b.voteSource = ""
+	deadline := b.clock.After(timeout)
+	for {
+		select {
+		case ret := <-c:
+			buttons := filterButtons(ret.Buttons)
+			if (buttons == "") {
+				continue
+			}
+			b.voteSource = ret.Source
+			b.voteAt = b.clock.Now()
+			if config.InputDraws() && (b.state == StateDuel || b.state == StateMatch) {
+				return b.waitForDraw(c, buttons)
+			}
+			return buttons[:1]
+		case <-deadline:
+			return ""
+		}
+	}
+}
+
+func filterButtons(input []byte) string {
+	draws := config.InputDraws()
+	buttons := ""
+	for _, b := range(input) {
+		if (b == '1' || b == '2' || (draws && b == '3')) {
+			buttons = buttons + string(b)
+		}
+	}
+	return buttons
+}
+
+/* waitForDraw gives the other button a moment to follow the first one.
+   Both buttons pressed together, or button 3, make a draw. */
+func (b *Battle) waitForDraw(c <-chan input.Vote, buttons string) string {
+	isDraw := func(s string) bool {
+		return strings.Contains(s, "3") || (strings.Contains(s, "1") && strings.Contains(s, "2"))
+	}
+	if isDraw(buttons) {
+		return "3"
+	}
+	window := b.clock.After(config.InputDrawWindow())
+	for {
+		select {
+		case ret := <-c:
+			buttons = buttons + filterButtons(ret.Buttons)
+			if isDraw(buttons) {
+				return "3"
+			}
+		case <-window:
+			return buttons[:1]
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package battle
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/input"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+const testConfiguration = `
+server:
+  port: 5000
+database:
+  driver: "memory"
+serial_port:
+  baud_rate: 9600
+input:
+  sources: ["stdin"]
+images:
+  path: "images/"
+timings:
+  duel_timeout: 20
+  leaderboard: 15
+  splash_screen: 15
+`
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "battle")
+	if err != nil {
+		panic(err)
+	}
+	file := filepath.Join(dir, "artbattle.conf.yaml")
+	err = os.WriteFile(file, []byte(testConfiguration), 0644)
+	if err == nil {
+		flag.Set("config", file)
+		err = config.StartupLoadConfiguration()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+/* fakeClock lets every wait expire at once, moving on by its duration */
+type fakeClock struct {
+	lock	sync.Mutex
+	now	time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+/* recorder keeps what was broadcast */
+type recorder struct {
+	lock	sync.Mutex
+	msgs	[]string
+}
+
+func (r *recorder) Broadcast(msg []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.msgs = append(r.msgs, string(msg))
+	return nil
+}
+
+/* last returns the type of the message broadcast last */
+func (r *recorder) last() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.msgs) == 0 {
+		return ""
+	}
+	msgType, _, _ := strings.Cut(r.msgs[len(r.msgs) - 1], ":")
+	return msgType
+}
+
+func addArtworks(t *testing.T, repo database.Repository, count int) {
+	for i := 0; i < count; i++ {
+		addRatedArtworks(t, repo, 800)
+	}
+}
+
+/* addRatedArtworks adds an artwork for each rating, by artists of their
+   own */
+func addRatedArtworks(t *testing.T, repo database.Repository, ratings ...float64) []*database.Artwork {
+	var res []*database.Artwork
+	for _, rating := range ratings {
+		all, err := repo.GetAllArtworks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := len(all) + 1
+		a := &database.Artwork{
+			Title: fmt.Sprintf("Artwork %d", n),
+			Artist: fmt.Sprintf("Artist %d", n),
+			Panel: fmt.Sprintf("A%d", n),
+			Filename: fmt.Sprintf("artwork%d.jpg", n),
+			EloRating: rating,
+		}
+		err = repo.AddArtwork(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+/* step performs one step and checks the state it leads to, and the
+   screen shown on the way, if any */
+func step(t *testing.T, b *Battle, out *recorder, state State, screen string) {
+	t.Helper()
+	b.Step()
+	if b.State() != state {
+		t.Fatalf("got state %s, want %s (last error: %s)", b.State(), state, b.LastError())
+	}
+	if screen != "" && out.last() != screen {
+		t.Fatalf("in state %s, got screen %s, want %s", state, out.last(), screen)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	addArtworks(t, repo, 2)
+	out := &recorder{}
+	clock := &fakeClock{now: time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)}
+	b := New(repo, make(ChannelInput), out, clock)
+
+	step(t, b, out, StateDuel, "")
+	step(t, b, out, StateTimeout, "DUEL")
+	/* the displays count down the time for the vote */
+	if !strings.Contains(out.msgs[len(out.msgs) - 1], `"timeout_ms":20000`) {
+		t.Errorf("got %s, want the duel timeout", out.msgs[len(out.msgs) - 1])
+	}
+	step(t, b, out, StateLeaderboard, "TIMEOUT")
+
+	duels, err := repo.GetAllDuels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duels) != 1 || !duels[0].TimedOut {
+		t.Fatalf("got %d duels, want one timed out", len(duels))
+	}
+	if duels[0].ShownAt == nil || duels[0].When.Sub(*duels[0].ShownAt) != 20 * time.Second {
+		t.Errorf("got duel shown at %v until %v, want the whole duel timeout", duels[0].ShownAt, duels[0].When)
+	}
+	for _, a := range []uint{duels[0].Duelist1, duels[0].Duelist2} {
+		artwork, err := repo.GetArtworkById(int64(a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if artwork.DuelCount != 0 || artwork.EloRating != 800 {
+			t.Errorf("artwork %d: got %d duels, rating %v, want a timeout to change neither", a, artwork.DuelCount, artwork.EloRating)
+		}
+	}
+
+	step(t, b, out, StateSplashScreen, "LEADERBOARD")
+	step(t, b, out, StateDuel, "SPLASH")
+}
+
+func TestError(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	addArtworks(t, repo, 1)
+	out := &recorder{}
+	clock := &fakeClock{now: time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)}
+	b := New(repo, make(ChannelInput), out, clock)
+
+	step(t, b, out, StateDuel, "")
+	/* one artwork can't duel */
+	step(t, b, out, StateError, "")
+	if !strings.HasPrefix(b.LastError(), "Duel error: ") {
+		t.Errorf("got error %q, want a duel error", b.LastError())
+	}
+	start := clock.Now()
+	step(t, b, out, StateDuel, "ERROR")
+	if clock.Now().Sub(start) != 30 * time.Second {
+		t.Errorf("error shown for %s, want 30s", clock.Now().Sub(start))
+	}
+
+	/* and duels go on once there is a second one */
+	addArtworks(t, repo, 1)
+	step(t, b, out, StateTimeout, "DUEL")
+}
+
+/* withFinals switches the finals on for the rest of the test */
+func withFinals(t *testing.T, format string) {
+	c := config.Configuration()
+	saved := c.Finals
+	c.Finals.Enabled = true
+	c.Finals.Format = format
+	t.Cleanup(func() {
+		c.Finals = saved
+	})
+}
+
+func TestFinalsTooFewArtworks(t *testing.T) {
+	withFinals(t, "single")
+	repo := database.NewMemoryRepository()
+	addArtworks(t, repo, 1)
+	out := &recorder{}
+	clock := &fakeClock{now: time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)}
+	b := New(repo, make(ChannelInput), out, clock)
+
+	step(t, b, out, StateDuel, "")
+	/* no bracket with one artwork, the open duels go on */
+	step(t, b, out, StateError, "")
+	if !strings.HasPrefix(b.LastError(), "Duel error: ") {
+		t.Errorf("got error %q, want a duel error", b.LastError())
+	}
+	step(t, b, out, StateDuel, "ERROR")
+
+	addArtworks(t, repo, 1)
+	step(t, b, out, StateBracket, "")
+	step(t, b, out, StateMatch, "BRACKET")
+}
+
+func TestFinalsDoubleFallsBackToSingle(t *testing.T) {
+	withFinals(t, "double")
+	repo := database.NewMemoryRepository()
+	addArtworks(t, repo, 3)
+	out := &recorder{}
+	clock := &fakeClock{now: time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)}
+	b := New(repo, make(ChannelInput), out, clock)
+
+	step(t, b, out, StateDuel, "")
+	step(t, b, out, StateBracket, "")
+	step(t, b, out, StateMatch, "BRACKET")
+	bracket, err := repo.GetCurrentBracket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bracket == nil || bracket.Format != "single" {
+		t.Fatalf("got bracket %+v, want a single elimination one", bracket)
+	}
+}
+
+/* manualClock hands every wait to the test, which moves the time on and
+   lets it expire */
+type manualClock struct {
+	fakeClock
+	waits	chan chan time.Time
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.waits <- ch
+	return ch
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func withDraws(t *testing.T) {
+	c := config.Configuration()
+	saved := c.Input
+	c.Input.Draws = true
+	c.Input.DrawWindowMs = 300
+	t.Cleanup(func() {
+		c.Input = saved
+	})
+}
+
+func TestDrawWindow(t *testing.T) {
+	withDraws(t)
+	repo := database.NewMemoryRepository()
+	addArtworks(t, repo, 2)
+	out := &recorder{}
+	clock := &manualClock{
+		fakeClock: fakeClock{now: time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)},
+		waits: make(chan chan time.Time),
+	}
+	in := make(ChannelInput)
+	b := New(repo, in, out, clock)
+	b.state = StateDuel
+
+	done := make(chan bool)
+	go func() {
+		b.Step()
+		done <- true
+	}()
+	<-clock.waits
+	clock.advance(1500 * time.Millisecond)
+	in <- input.Vote{Buttons: []byte("1"), Source: "serial"}
+	/* the other button may still follow */
+	window := <-clock.waits
+	clock.advance(300 * time.Millisecond)
+	window <- clock.Now()
+	<-done
+
+	if b.State() != StateDecision || b.vote != "1" {
+		t.Fatalf("got state %s with vote %q, want a decision for 1", b.State(), b.vote)
+	}
+	if d := b.shown.endedAt.Sub(b.shown.shownAt); d != 1500 * time.Millisecond {
+		t.Errorf("got %s to decide, want 1.5s without the draw window", d)
+	}
+
+	/* outside of duels a button moves on right away */
+	b.state = StateLeaderboard
+	go func() {
+		b.Step()
+		done <- true
+	}()
+	<-clock.waits
+	in <- input.Vote{Buttons: []byte("1"), Source: "serial"}
+	select {
+	case <-done:
+	case <-clock.waits:
+		t.Fatal("waited for a draw on the leaderboard")
+	}
+	if b.State() != StateSplashScreen {
+		t.Errorf("got state %s, want %s", b.State(), StateSplashScreen)
+	}
+}
@@ -0,0 +1,206 @@
+package battle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+	"github.com/tinx/proto-artbattle/rating"
+)
+
+/* presentation is how a duel went on the displays */
+type presentation struct {
+	shownAt		time.Time
+	/* when the vote came in, or the time was up */
+	endedAt		time.Time
+	/* the input source of the vote, "" without one */
+	source		string
+}
+
+/* record fills in when and where the duel took place */
+func (p presentation) record(duel *database.Duel) {
+	shownAt := p.shownAt
+	duel.When = p.endedAt
+	duel.ShownAt = &shownAt
+	if p.source != "" {
+		duel.DecisionMs = p.endedAt.Sub(p.shownAt).Milliseconds()
+	}
+	duel.Source = p.source
+	duel.Kiosk = config.ServerKiosk()
+}
+
+func processDecision(db Repository, a1 *database.Artwork, a2 *database.Artwork, decision byte, p presentation) (string, error) {
+	var dto DecisionDTO
+	var winner string
+	algorithm := rating.FromConfiguration()
+	dto.Algorithm = algorithm.Name()
+	process_decision := func(tx database.Tx) error {
+		a1_rank_old, err := tx.GetArtworkRank(a1)
+		if err != nil {
+			return err
+		}
+		a2_rank_old, err := tx.GetArtworkRank(a2)
+		if err != nil {
+			return err
+		}
+		var duel database.Duel;
+		duel.Duelist1 = a1.ID
+		duel.Duelist2 = a2.ID
+		p.record(&duel)
+		duel.Rating1Before = a1.EloRating
+		duel.Rating2Before = a2.EloRating
+		/* Adjust depending on decision */
+		a1ed, a2ed, err := rate(algorithm, a1, a2, decision)
+		if err != nil {
+			return err
+		}
+		if decision == '1' {
+			winner = "one"
+			duel.Winner = a1.ID
+		} else if decision == '3' {
+			/* Winner 0 is a draw */
+			winner = "draw"
+			duel.Winner = 0
+		} else {
+			winner = "two"
+			duel.Winner = a2.ID
+		}
+
+		dto.OneEloDiff = roundRating(a1ed)
+		dto.TwoEloDiff = roundRating(a2ed)
+
+		err = tx.UpdateRatings(a1)
+		if err != nil {
+			return fmt.Errorf("error updating artwork: %s", err)
+		}
+		err = tx.UpdateRatings(a2)
+		if err != nil {
+			return fmt.Errorf("error updating artwork: %s", err)
+		}
+		a1_rank_new, err := tx.GetArtworkRank(a1)
+		if err != nil {
+			return err
+		}
+		a2_rank_new, err := tx.GetArtworkRank(a2)
+		if err != nil {
+			return err
+		}
+
+		duel.Rating1After = a1.EloRating
+		duel.Rating2After = a2.EloRating
+		duel.Rank1Before = a1_rank_old
+		duel.Rank1After = a1_rank_new
+		duel.Rank2Before = a2_rank_old
+		duel.Rank2After = a2_rank_new
+
+		dto.OneRankDiff = a1_rank_old - a1_rank_new
+		dto.TwoRankDiff = a2_rank_old - a2_rank_new
+		dto.Winner = winner
+
+		err = tx.AddDuel(&duel)
+		if err != nil {
+			return fmt.Errorf("error logging duel: %s", err)
+		}
+		return nil
+	}
+	err := db.Transaction(process_decision)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error processnig decision: %s\n", err)
+		return "", err
+	}
+
+	encodeArtworkToDTO(a1, &dto.One)
+	encodeArtworkToDTO(a2, &dto.Two)
+
+	j, err := json.Marshal(dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return "", err
+	}
+	return string(j), nil
+}
+
+/* recordTimeout logs a duel nobody voted in. The ratings stay as they
+   are. */
+func recordTimeout(db Repository, a1 *database.Artwork, a2 *database.Artwork, p presentation) error {
+	err := db.Transaction(func(tx database.Tx) error {
+		rank1, err := tx.GetArtworkRank(a1)
+		if err != nil {
+			return err
+		}
+		rank2, err := tx.GetArtworkRank(a2)
+		if err != nil {
+			return err
+		}
+		duel := database.Duel{
+			Duelist1: a1.ID,
+			Duelist2: a2.ID,
+			Rating1Before: a1.EloRating,
+			Rating1After: a1.EloRating,
+			Rating2Before: a2.EloRating,
+			Rating2After: a2.EloRating,
+			Rank1Before: rank1,
+			Rank1After: rank1,
+			Rank2Before: rank2,
+			Rank2After: rank2,
+			TimedOut: true,
+		}
+		p.record(&duel)
+		err = tx.AddDuel(&duel)
+		if err != nil {
+			return fmt.Errorf("error logging duel: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error recording timeout: %s\n", err)
+	}
+	return err
+}
+
+/* rate updates the ratings of both artworks after a decision and returns
+   the changes. */
+func rate(algorithm rating.Algorithm, a1 *database.Artwork, a2 *database.Artwork, decision byte) (float64, float64, error) {
+	var score float64
+	if decision == '1' {
+		score = 1.0
+	} else if decision == '2' {
+		score = 0.0
+	} else if decision == '3' {
+		score = 0.5
+	} else {
+		return 0, 0, fmt.Errorf("unexpected decision: %c", decision)
+	}
+	p1, p2 := algorithm.Update(playerOf(a1), playerOf(a2), score)
+	a1ed := applyPlayer(a1, p1)
+	a2ed := applyPlayer(a2, p2)
+	a1.DuelCount = a1.DuelCount + 1
+	a2.DuelCount = a2.DuelCount + 1
+	return a1ed, a2ed, nil
+}
+
+func playerOf(a *database.Artwork) rating.Player {
+	return rating.Player{
+		Rating: a.EloRating,
+		Deviation: a.RatingDeviation,
+		Volatility: a.RatingVolatility,
+		DuelCount: a.DuelCount,
+	}
+}
+
+/* applyPlayer stores the new rating, but never below rating.floor, and
+   returns the change. */
+func applyPlayer(a *database.Artwork, p rating.Player) float64 {
+	r := p.Rating
+	if r < config.RatingFloor() {
+		r = config.RatingFloor()
+	}
+	diff := r - a.EloRating
+	a.EloRating = r
+	a.RatingDeviation = p.Deviation
+	a.RatingVolatility = p.Volatility
+	return diff
+}
@@ -0,0 +1,124 @@
+package battle
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+func decide(t *testing.T, repo database.Repository, a1, a2 *database.Artwork, decision byte) DecisionDTO {
+	t.Helper()
+	shownAt := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	p := presentation{
+		shownAt: shownAt,
+		endedAt: shownAt.Add(1500 * time.Millisecond),
+		source: "serial",
+	}
+	j, err := processDecision(repo, a1, a2, decision, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dto DecisionDTO
+	err = json.Unmarshal([]byte(j), &dto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dto
+}
+
+func onlyDuel(t *testing.T, repo database.Repository) *database.Duel {
+	t.Helper()
+	duels, err := repo.GetAllDuels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duels) != 1 {
+		t.Fatalf("got %d duels, want 1", len(duels))
+	}
+	return duels[0]
+}
+
+func TestDecision(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	fix := addRatedArtworks(t, repo, 800, 805, 810)
+	low, high := fix[0], fix[2]
+
+	/* the underdog wins against the leader */
+	dto := decide(t, repo, low, high, '1')
+	gain := 16 * (1 - 1 / (1 + math.Pow(10, 10.0 / 400)))
+
+	if dto.Winner != "one" || dto.Algorithm != "elo" {
+		t.Errorf("got winner %s by %s, want one by elo", dto.Winner, dto.Algorithm)
+	}
+	if dto.OneEloDiff != 8 || dto.TwoEloDiff != -8 {
+		t.Errorf("got rating changes %d and %d, want 8 and -8", dto.OneEloDiff, dto.TwoEloDiff)
+	}
+	/* from 3rd to 1st, and from 1st to 3rd */
+	if dto.OneRankDiff != 2 || dto.TwoRankDiff != -2 {
+		t.Errorf("got rank changes %d and %d, want 2 and -2", dto.OneRankDiff, dto.TwoRankDiff)
+	}
+	if dto.One.EloRating != 808 || dto.Two.EloRating != 802 || dto.One.DuelCount != 1 {
+		t.Errorf("got ratings %d and %d after %d duels, want 808 and 802 after 1", dto.One.EloRating, dto.Two.EloRating, dto.One.DuelCount)
+	}
+
+	for _, want := range []struct {
+		a	*database.Artwork
+		rating	float64
+	}{
+		{low, 800 + gain},
+		{fix[1], 805},
+		{high, 810 - gain},
+	} {
+		a, err := repo.GetArtworkById(int64(want.a.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(a.EloRating - want.rating) > 1e-9 {
+			t.Errorf("%s: got rating %v, want %v", a.Title, a.EloRating, want.rating)
+		}
+	}
+
+	d := onlyDuel(t, repo)
+	if d.Duelist1 != low.ID || d.Duelist2 != high.ID || d.Winner != low.ID {
+		t.Errorf("got duel %d vs %d won by %d, want %d vs %d won by %d", d.Duelist1, d.Duelist2, d.Winner, low.ID, high.ID, low.ID)
+	}
+	if d.Rating1Before != 800 || d.Rating2Before != 810 || math.Abs(d.Rating1After - (800 + gain)) > 1e-9 || math.Abs(d.Rating2After - (810 - gain)) > 1e-9 {
+		t.Errorf("got ratings %v -> %v and %v -> %v", d.Rating1Before, d.Rating1After, d.Rating2Before, d.Rating2After)
+	}
+	if d.Rank1Before != 3 || d.Rank1After != 1 || d.Rank2Before != 1 || d.Rank2After != 3 {
+		t.Errorf("got ranks %d -> %d and %d -> %d, want 3 -> 1 and 1 -> 3", d.Rank1Before, d.Rank1After, d.Rank2Before, d.Rank2After)
+	}
+	if d.DecisionMs != 1500 || d.Source != "serial" || d.TimedOut {
+		t.Errorf("got %d ms by %q, timed out %v, want 1500 ms by serial", d.DecisionMs, d.Source, d.TimedOut)
+	}
+}
+
+func TestDraw(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	fix := addRatedArtworks(t, repo, 800, 800)
+
+	dto := decide(t, repo, fix[0], fix[1], '3')
+
+	if dto.Winner != "draw" {
+		t.Errorf("got winner %s, want draw", dto.Winner)
+	}
+	if dto.OneEloDiff != 0 || dto.TwoEloDiff != 0 || dto.OneRankDiff != 0 || dto.TwoRankDiff != 0 {
+		t.Errorf("got changes %d, %d, ranks %d, %d, want none between equals", dto.OneEloDiff, dto.TwoEloDiff, dto.OneRankDiff, dto.TwoRankDiff)
+	}
+	for _, f := range fix {
+		a, err := repo.GetArtworkById(int64(f.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.EloRating != 800 || a.DuelCount != 1 {
+			t.Errorf("%s: got rating %v after %d duels, want 800 after 1", a.Title, a.EloRating, a.DuelCount)
+		}
+	}
+	d := onlyDuel(t, repo)
+	if d.Winner != 0 {
+		t.Errorf("got winner %d, want 0 for a draw", d.Winner)
+	}
+}
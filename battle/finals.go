@@ -0,0 +1,360 @@
+package battle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/finals"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+type MatchDTO struct {
+	Number		int `json:"number"`
+	Round		string `json:"round"`
+	/* artwork ids, 0 while not known yet */
+	One		uint `json:"one"`
+	Two		uint `json:"two"`
+	OneWins		int `json:"one_wins"`
+	TwoWins		int `json:"two_wins"`
+	Winner		uint `json:"winner"`
+}
+
+type BracketDTO struct {
+	/* single or double */
+	Format		string `json:"format"`
+	BestOf		int `json:"best_of"`
+	Category	string `json:"category"`
+	/* best seed first */
+	Seeds		[]ArtworkDTO `json:"seeds"`
+	Matches		[]MatchDTO `json:"matches"`
+	/* number of the match up next, -1 once there is a champion */
+	Next		int `json:"next"`
+	Champion	uint `json:"champion"`
+}
+
+type MatchResultDTO struct {
+	One		ArtworkDTO `json:"one"`
+	Two		ArtworkDTO `json:"two"`
+	Match		MatchDTO `json:"match"`
+	BestOf		int `json:"best_of"`
+	/* one or two */
+	Vote		string `json:"vote"`
+	/* one or two once the match is decided, "" before */
+	Winner		string `json:"winner"`
+}
+
+type ChampionDTO struct {
+	Champion	ArtworkDTO `json:"champion"`
+	Category	string `json:"category"`
+}
+
+func encodeMatchToDTO(m *database.Match) MatchDTO {
+	return MatchDTO{
+		Number: m.Number,
+		Round: m.Round,
+		One: m.Artwork1,
+		Two: m.Artwork2,
+		OneWins: m.Wins1,
+		TwoWins: m.Wins2,
+		Winner: m.Winner,
+	}
+}
+
+/* loadFinals returns the current bracket and its matches. The first time,
+   the bracket is seeded from the top of the leaderboard. */
+func (b *Battle) loadFinals() (*database.Bracket, []*database.Match, error) {
+	bracket, err := b.repo.GetCurrentBracket()
+	if err != nil {
+		return nil, nil, err
+	}
+	if bracket == nil {
+		bracket, err = b.startFinals()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	matches, err := b.repo.GetMatches(bracket.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bracket, matches, nil
+}
+
+/* finalsReady tells whether the finals can go on: either a bracket is
+   under way, or there are enough artworks to seed one. Until then, the
+   open duels go on. */
+func (b *Battle) finalsReady() (bool, error) {
+	bracket, err := b.repo.GetCurrentBracket()
+	if err != nil {
+		return false, err
+	}
+	if bracket == nil {
+		lb, err := b.repo.GetLeaderboard(2, config.FinalsCategory())
+		if err != nil {
+			return false, err
+		}
+		if len(lb) < 2 {
+			if !b.finalsWaiting {
+				fmt.Fprintf(os.Stderr, "finals need at least 2 artworks, there are %d, going on with open duels\n", len(lb))
+				b.finalsWaiting = true
+			}
+			return false, nil
+		}
+	}
+	b.finalsWaiting = false
+	return true, nil
+}
+
+func (b *Battle) startFinals() (*database.Bracket, error) {
+	lb, err := b.repo.GetLeaderboard(config.FinalsSize(), config.FinalsCategory())
+	if err != nil {
+		return nil, err
+	}
+	/* with fewer artworks than planned, the largest bracket possible */
+	n := 1
+	for n * 2 <= len(lb) {
+		n = n * 2
+	}
+	if n > len(lb) {
+		n = len(lb)
+	}
+	format := config.FinalsFormat()
+	if format == finals.Double && n < 4 {
+		fmt.Fprintf(os.Stderr, "double elimination needs at least 4 artworks, there are %d, using single elimination\n", n)
+		format = finals.Single
+	}
+	var seeds []uint
+	for _, a := range lb[:n] {
+		seeds = append(seeds, a.ID)
+	}
+	matches, err := finals.Build(format, seeds)
+	if err != nil {
+		return nil, err
+	}
+	bracket := &database.Bracket{
+		Format: format,
+		BestOf: config.FinalsBestOf(),
+		Category: config.FinalsCategory(),
+		Seeds: finals.FormatSeeds(seeds),
+	}
+	err = b.repo.AddBracket(bracket, matches)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "finals started with %d artworks\n", n)
+	return bracket, nil
+}
+
+/* artworksById includes removed artworks, they may still be part of the
+   bracket */
+func (b *Battle) artworksById() (map[uint]*database.Artwork, error) {
+	res := map[uint]*database.Artwork{}
+	err := b.repo.Transaction(func(tx database.Tx) error {
+		all, err := tx.GetAllArtworksWithRemoved()
+		if err != nil {
+			return err
+		}
+		for _, a := range all {
+			res[a.ID] = a
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (b *Battle) encodeBracket(bracket *database.Bracket, matches []*database.Match) (string, error) {
+	artworks, err := b.artworksById()
+	if err != nil {
+		return "", err
+	}
+	dto := BracketDTO{
+		Format: bracket.Format,
+		BestOf: bracket.BestOf,
+		Category: bracket.Category,
+		Seeds: []ArtworkDTO{},
+		Matches: []MatchDTO{},
+		Next: -1,
+		Champion: bracket.Champion,
+	}
+	for _, id := range finals.Seeds(bracket) {
+		var aw_dto ArtworkDTO
+		a, ok := artworks[id]
+		if ok {
+			encodeArtworkToDTO(a, &aw_dto)
+		} else {
+			aw_dto.ID = id
+		}
+		dto.Seeds = append(dto.Seeds, aw_dto)
+	}
+	for _, m := range matches {
+		dto.Matches = append(dto.Matches, encodeMatchToDTO(m))
+	}
+	next := finals.Next(matches)
+	if next != nil {
+		dto.Next = next.Number
+	}
+	j, err := json.Marshal(dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return "", err
+	}
+	return string(j), nil
+}
+
+/* stepBracket shows the bracket, then the next match or the champion. */
+func (b *Battle) stepBracket() {
+	bracket, matches, err := b.loadFinals()
+	if err != nil {
+		b.fail("Bracket error: %s", err)
+		return
+	}
+	json, err := b.encodeBracket(bracket, matches)
+	if err != nil {
+		b.fail("Bracket error: %s", err)
+		return
+	}
+	b.show("BRACKET", json, config.TimingsLeaderboard() * time.Second)
+	if bracket.Champion != 0 {
+		b.state = StateChampion
+	} else {
+		b.state = StateMatch
+	}
+}
+
+/* stepMatch shows the next match as a duel and counts the vote. Draws
+   don't count in the finals, the duel is shown again. */
+func (b *Battle) stepMatch() {
+	bracket, matches, err := b.loadFinals()
+	if err != nil {
+		b.fail("Match error: %s", err)
+		return
+	}
+	m := finals.Next(matches)
+	if m == nil {
+		b.state = StateChampion
+		return
+	}
+	artworks, err := b.artworksById()
+	if err != nil {
+		b.fail("Match error: %s", err)
+		return
+	}
+	a1, ok1 := artworks[m.Artwork1]
+	a2, ok2 := artworks[m.Artwork2]
+	if !ok1 || !ok2 {
+		b.fail("Match error: %s", fmt.Errorf("unknown artwork in match %d", m.Number))
+		return
+	}
+	b.a1, b.a2 = a1, a2
+
+	var dto DuelDTO
+	encodeArtworkToDTO(a1, &dto.One)
+	encodeArtworkToDTO(a2, &dto.Two)
+	match := encodeMatchToDTO(m)
+	dto.Match = &match
+	timeout := config.TimingsDuelTimeout() * time.Second
+	dto.TimeoutMs = timeout.Milliseconds()
+	j, err := json.Marshal(dto)
+	if err != nil {
+		b.fail("Match error: %s", err)
+		return
+	}
+	vote := b.show("DUEL", string(j), timeout)
+	if vote == "" {
+		b.state = StateBracket
+		return
+	}
+	if vote == "3" {
+		return
+	}
+
+	winner := a1.ID
+	if vote == "2" {
+		winner = a2.ID
+	}
+	err = b.repo.Transaction(func(tx database.Tx) error {
+		changed, err := finals.Vote(matches, m, winner, bracket.BestOf)
+		if err != nil {
+			return err
+		}
+		for _, c := range changed {
+			err = tx.UpdateMatch(c)
+			if err != nil {
+				return err
+			}
+		}
+		bracket.Champion = finals.Champion(matches)
+		if bracket.Champion != 0 {
+			return tx.UpdateBracket(bracket)
+		}
+		return nil
+	})
+	if err != nil {
+		b.fail("Match error: %s", err)
+		return
+	}
+
+	result := MatchResultDTO{
+		One: dto.One,
+		Two: dto.Two,
+		Match: encodeMatchToDTO(m),
+		BestOf: bracket.BestOf,
+		Vote: "one",
+	}
+	if vote == "2" {
+		result.Vote = "two"
+	}
+	if m.Winner == a1.ID {
+		result.Winner = "one"
+	} else if m.Winner == a2.ID {
+		result.Winner = "two"
+	}
+	j, err = json.Marshal(result)
+	if err != nil {
+		b.fail("Match error: %s", err)
+		return
+	}
+	if m.Winner == 0 {
+		b.show("MATCH_RESULT", string(j), 2 * time.Second)
+		return
+	}
+	b.show("MATCH_RESULT", string(j), 5 * time.Second)
+	b.state = StateBracket
+}
+
+/* stepChampion celebrates the winner of the finals, taking turns with
+   the final bracket for as long as the finals are on. */
+func (b *Battle) stepChampion() {
+	bracket, _, err := b.loadFinals()
+	if err != nil {
+		b.fail("Champion error: %s", err)
+		return
+	}
+	if bracket.Champion == 0 {
+		b.state = StateMatch
+		return
+	}
+	artworks, err := b.artworksById()
+	if err != nil {
+		b.fail("Champion error: %s", err)
+		return
+	}
+	var dto ChampionDTO
+	dto.Category = bracket.Category
+	a, ok := artworks[bracket.Champion]
+	if ok {
+		encodeArtworkToDTO(a, &dto.Champion)
+	} else {
+		dto.Champion.ID = bracket.Champion
+	}
+	j, err := json.Marshal(dto)
+	if err != nil {
+		b.fail("Champion error: %s", err)
+		return
+	}
+	b.show("CHAMPION", string(j), config.TimingsLeaderboard() * time.Second)
+	b.state = StateBracket
+}
@@ -0,0 +1,164 @@
+package battle
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+/* ratings are rounded for display, see roundRating */
+type ArtworkDTO struct {
+	ID		uint   `json:"id"`
+	Title		string `json:"title"`
+	Artist		string `json:"artist"`
+	Filename	string `json:"filename"`
+	Thumbnail	string `json:"thumbnail"`
+	ScreenImage	string `json:"screen_image"`
+	Panel		string `json:"panel"`
+	Category	string `json:"category"`
+	EloRating	int16 `json:"elo_rating"`
+	RatingDeviation	float64 `json:"rating_deviation"`
+	RatingVolatility	float64 `json:"rating_volatility"`
+	DuelCount	uint64 `json:"duel_count"`
+}
+
+type DuelDTO struct {
+	One		ArtworkDTO `json:"one"`
+	Two		ArtworkDTO `json:"two"`
+	/* only in the finals */
+	Match		*MatchDTO `json:"match,omitempty"`
+	/* how long the vote lasts, only with DUEL */
+	TimeoutMs	int64 `json:"timeout_ms,omitempty"`
+}
+
+type LeaderboardDTO struct {
+	/* "" if artworks are not split into categories */
+	Category	string `json:"category"`
+	Count		int `json:"count"`
+	Entries		[]ArtworkDTO `json:"entries"`
+}
+
+type DecisionDTO struct {
+	One		ArtworkDTO `json:"one"`
+	Two		ArtworkDTO `json:"two"`
+	/* one, two or draw */
+	Winner		string `json:"winner"`
+	Algorithm	string `json:"rating_algorithm"`
+	OneEloDiff	int16 `json:"one_elo_diff"`
+	OneRankDiff	int64 `json:"one_rank_diff"`
+	TwoEloDiff	int16 `json:"two_elo_diff"`
+	TwoRankDiff	int64 `json:"two_rank_diff"`
+}
+
+type ErrorDTO struct {
+	Message		string `json:"message"`
+}
+
+type SplashscreenDTO struct {
+	DuelCount	int64 `json:"duel_count"`
+}
+
+type StateDTO struct {
+	State		string `json:"state"`
+	Screen		string `json:"screen"`
+	RemainingMs	int64 `json:"remaining_ms"`
+}
+
+type InputStatusDTO struct {
+	Connected	bool `json:"connected"`
+	Disconnected	[]string `json:"disconnected"`
+	Message		string `json:"message"`
+}
+
+type ButtonDTO struct {
+	Button		string `json:"button"`
+}
+
+/* roundRating rounds a rating or a rating change for the displays */
+func roundRating(r float64) int16 {
+	return int16(math.Round(r))
+}
+
+func encodeArtworkToDTO(a *database.Artwork, dto *ArtworkDTO) {
+	dto.ID = a.ID
+	dto.Title = a.Title
+	dto.Artist = a.Artist
+	dto.Filename = a.Filename
+	dto.Thumbnail = a.Thumbnail
+	dto.ScreenImage = a.ScreenImage
+	dto.Panel = a.Panel
+	dto.Category = a.Category
+	dto.EloRating = roundRating(a.EloRating)
+	dto.RatingDeviation = a.RatingDeviation
+	dto.RatingVolatility = a.RatingVolatility
+	dto.DuelCount = a.DuelCount
+}
+
+func encodeDuelToJson(a1, a2 *database.Artwork, timeout time.Duration) (string, error) {
+	var dto DuelDTO
+	encodeArtworkToDTO(a1, &dto.One)
+	encodeArtworkToDTO(a2, &dto.Two)
+	dto.TimeoutMs = timeout.Milliseconds()
+	j, err := json.Marshal(dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return "", err
+	}
+	return string(j), nil
+}
+
+func getLeaderboard(db Repository, category string) (string, error) {
+	lb, err := db.GetLeaderboard(10, category)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting leaderboard: %s\n", err)
+		return "", err
+	}
+	dto, err := encodeLeaderboardToDTO(lb, category)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding leaderboard: %s\n", err)
+		return "", err
+	}
+	j, err := json.Marshal(dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return "", err
+	}
+	return string(j), nil
+}
+
+func encodeLeaderboardToDTO(lb []*database.Artwork, category string) (string, error) {
+	var dto LeaderboardDTO
+	dto.Category = category
+	dto.Count = len(lb)
+	for _, a := range lb {
+		var aw_dto ArtworkDTO
+		encodeArtworkToDTO(a, &aw_dto)
+		dto.Entries = append(dto.Entries, aw_dto)
+	}
+	j, err := json.Marshal(dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return "", err
+	}
+	return string(j), nil
+}
+
+func getSplashScreen(db Repository) (string, error) {
+	var dto SplashscreenDTO;
+	count, err := db.GetTotalDuelCount()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting total duel count: %s\n", err)
+		return "", err
+	}
+	dto.DuelCount = count
+	j, err := json.Marshal(dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return "", err
+	}
+	return string(j), nil
+}
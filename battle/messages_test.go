@@ -0,0 +1,57 @@
+package battle
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+func TestLeaderboard(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	fix := addRatedArtworks(t, repo, 790.4, 812.6, 800)
+	err := repo.RemoveArtwork(fix[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := getLeaderboard(repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* the leaderboard goes out as a JSON string holding the JSON, the
+	   displays decode it twice */
+	var j string
+	err = json.Unmarshal([]byte(msg), &j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"category":""`, `"count":2`, `"entries":[`, `"elo_rating":813`, `"panel":"A2"`, `"filename":"artwork2.jpg"`} {
+		if !strings.Contains(j, field) {
+			t.Errorf("%s lacks %s", j, field)
+		}
+	}
+
+	var dto LeaderboardDTO
+	err = json.Unmarshal([]byte(j), &dto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dto.Count != 2 || len(dto.Entries) != 2 {
+		t.Fatalf("got %d entries, count %d, want the 2 not removed", len(dto.Entries), dto.Count)
+	}
+	/* best first, ratings rounded */
+	for i, want := range []struct {
+		id	uint
+		rating	int16
+	}{
+		{fix[1].ID, 813},
+		{fix[0].ID, 790},
+	} {
+		e := dto.Entries[i]
+		if e.ID != want.id || e.EloRating != want.rating {
+			t.Errorf("entry %d: got artwork %d rated %d, want %d rated %d", i, e.ID, e.EloRating, want.id, want.rating)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package battle
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+	"github.com/tinx/proto-artbattle/pairing"
+)
+
+type PreloadDTO struct {
+	/* the artworks of the next duels, soonest first */
+	Artworks	[]ArtworkDTO `json:"artworks"`
+}
+
+/* queuedDuel is a duel paired ahead of time */
+type queuedDuel struct {
+	a1, a2		*database.Artwork
+	/* the ratings when it was paired */
+	rating1		float64
+	rating2		float64
+	/* the rotation after this duel */
+	rotation	rotation
+}
+
+/* queue holds the duels paired ahead of time, with pairing.prefetch set.
+   They are paired in the background, one after the other, each one as if
+   the ones before had already been shown. */
+type queue struct {
+	lock		sync.Mutex
+	duels		[]*queuedDuel
+	/* the history and rotation of the duels shown so far */
+	history		*pairing.History
+	rotation	rotation
+	/* only used by the background pairing */
+	rng		*rand.Rand
+	filling		bool
+	/* changes whenever what was paired may be out of date, so a duel
+	   paired meanwhile is not queued */
+	generation	int
+}
+
+/* involves returns the rating of a when d was paired, if a is in d */
+func involves(d *queuedDuel, a *database.Artwork) (float64, bool) {
+	if d.a1.ID == a.ID {
+		return d.rating1, true
+	} else if d.a2.ID == a.ID {
+		return d.rating2, true
+	}
+	return 0, false
+}
+
+/* drop removes the duel at i and all after it, they were paired with it
+   in mind. Call with the lock held. */
+func (q *queue) drop(i int) {
+	if i < len(q.duels) {
+		q.duels = q.duels[:i]
+	}
+	q.generation = q.generation + 1
+}
+
+/* rated drops the queued duels from the first one with an artwork whose
+   rating has moved too far since it was paired. */
+func (q *queue) rated(artworks ...*database.Artwork) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i, d := range q.duels {
+		for _, a := range artworks {
+			rating, ok := involves(d, a)
+			if ok && math.Abs(a.EloRating - rating) > config.PairingPrefetchTolerance() {
+				q.drop(i)
+				return
+			}
+		}
+	}
+	/* duels being paired right now may have seen the old ratings */
+	q.generation = q.generation + 1
+}
+
+/* next returns the next duel, the first queued one if it is still good,
+   or else a newly paired one. */
+func (b *Battle) next() (*database.Artwork, *database.Artwork, error) {
+	q := &b.queue
+	q.lock.Lock()
+	if config.PairingPrefetch() == 0 {
+		q.drop(0)
+	}
+	var d *queuedDuel
+	if len(q.duels) > 0 {
+		d = q.duels[0]
+	}
+	r := q.rotation
+	q.lock.Unlock()
+
+	if d != nil {
+		a1, a2, ok := b.refresh(d)
+		q.lock.Lock()
+		if ok && len(q.duels) > 0 && q.duels[0] == d {
+			q.duels = q.duels[1:]
+			q.rotation = d.rotation
+			q.history.Add(a1.ID, a2.ID)
+			q.lock.Unlock()
+			return a1, a2, nil
+		}
+		q.drop(0)
+		q.lock.Unlock()
+	}
+
+	a1, a2, err := pair(b.repo, &r, q.history, b.rng)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.lock.Lock()
+	q.rotation = r
+	q.history.Add(a1.ID, a2.ID)
+	q.lock.Unlock()
+	return a1, a2, nil
+}
+
+/* refresh loads the artworks of a queued duel again, they may have
+   changed or been removed since it was paired. */
+func (b *Battle) refresh(d *queuedDuel) (*database.Artwork, *database.Artwork, bool) {
+	a1, err := b.repo.GetArtworkById(int64(d.a1.ID))
+	if err != nil {
+		return nil, nil, false
+	}
+	a2, err := b.repo.GetArtworkById(int64(d.a2.ID))
+	if err != nil {
+		return nil, nil, false
+	}
+	tolerance := config.PairingPrefetchTolerance()
+	if math.Abs(a1.EloRating - d.rating1) > tolerance || math.Abs(a2.EloRating - d.rating2) > tolerance {
+		return nil, nil, false
+	}
+	return a1, a2, true
+}
+
+/* prefetch starts pairing duels in the background until the queue is
+   full, unless that is already under way. */
+func (b *Battle) prefetch() {
+	q := &b.queue
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.filling || len(q.duels) >= config.PairingPrefetch() {
+		return
+	}
+	q.filling = true
+	go b.fill()
+}
+
+func (b *Battle) fill() {
+	q := &b.queue
+	added := false
+	for {
+		q.lock.Lock()
+		if len(q.duels) >= config.PairingPrefetch() {
+			q.filling = false
+			q.lock.Unlock()
+			break
+		}
+		generation := q.generation
+		rng := q.rng
+		history := q.history.Clone()
+		r := q.rotation
+		for _, d := range q.duels {
+			history.Add(d.a1.ID, d.a2.ID)
+			r = d.rotation
+		}
+		q.lock.Unlock()
+
+		a1, a2, err := pair(b.repo, &r, history, rng)
+		if err != nil {
+			q.lock.Lock()
+			q.filling = false
+			q.lock.Unlock()
+			break
+		}
+		q.lock.Lock()
+		if generation == q.generation {
+			q.duels = append(q.duels, &queuedDuel{
+				a1: a1,
+				a2: a2,
+				rating1: a1.EloRating,
+				rating2: a2.EloRating,
+				rotation: r,
+			})
+			added = true
+		}
+		q.lock.Unlock()
+	}
+	if added {
+		b.preload()
+	}
+}
+
+/* preload tells the displays which images come up next, so they can load
+   them ahead of time. */
+func (b *Battle) preload() {
+	var dto PreloadDTO
+	dto.Artworks = []ArtworkDTO{}
+	b.queue.lock.Lock()
+	for _, d := range b.queue.duels {
+		var one, two ArtworkDTO
+		encodeArtworkToDTO(d.a1, &one)
+		encodeArtworkToDTO(d.a2, &two)
+		dto.Artworks = append(dto.Artworks, one, two)
+	}
+	b.queue.lock.Unlock()
+	j, err := json.Marshal(&dto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json marhsal error: %s\n", err)
+		return
+	}
+	b.out.Broadcast([]byte("PRELOAD: " + string(j)))
+}
@@ -0,0 +1,191 @@
+package battle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+	"github.com/tinx/proto-artbattle/rating"
+)
+
+var errDryRun = errors.New("dry run")
+
+/* RecomputeRatings resets the ratings and duel counts of all artworks and
+   replays the whole duel history with the given algorithm, all in one
+   transaction. The ratings and ranks stored with each duel are
+   rewritten as well. A table of the old and new leaderboard positions is
+   written to out. With dryRun, nothing is changed. */
+func RecomputeRatings(db Repository, algorithm rating.Algorithm, dryRun bool, out io.Writer) error {
+	err := db.Transaction(func(tx database.Tx) error {
+		artworks, err := tx.GetAllArtworksWithRemoved()
+		if err != nil {
+			return err
+		}
+		duels, err := tx.GetAllDuels()
+		if err != nil {
+			return err
+		}
+
+		before := map[uint]float64{}
+		byId := map[uint]*database.Artwork{}
+		for _, a := range artworks {
+			before[a.ID] = a.EloRating
+			byId[a.ID] = a
+			a.EloRating = float64(config.RatingDefaultPoints())
+			a.RatingDeviation = config.RatingGlicko2InitialDeviation()
+			a.RatingVolatility = config.RatingGlicko2InitialVolatility()
+			a.DuelCount = 0
+		}
+
+		skipped := 0
+		timeouts := 0
+		for _, d := range duels {
+			a1, ok1 := byId[d.Duelist1]
+			a2, ok2 := byId[d.Duelist2]
+			if !ok1 || !ok2 {
+				fmt.Fprintf(os.Stderr, "skipping duel %d: unknown artwork\n", d.ID)
+				skipped = skipped + 1
+				continue
+			}
+			if d.TimedOut {
+				/* nothing to replay, but the ratings around it changed */
+				d.Rating1Before, d.Rating1After = a1.EloRating, a1.EloRating
+				d.Rating2Before, d.Rating2After = a2.EloRating, a2.EloRating
+				d.Rank1Before = rankAmong(artworks, a1)
+				d.Rank1After = d.Rank1Before
+				d.Rank2Before = rankAmong(artworks, a2)
+				d.Rank2After = d.Rank2Before
+				err = tx.UpdateDuel(d)
+				if err != nil {
+					return fmt.Errorf("error updating duel: %s", err)
+				}
+				timeouts = timeouts + 1
+				continue
+			}
+			var decision byte
+			if d.Winner == d.Duelist1 {
+				decision = '1'
+			} else if d.Winner == d.Duelist2 {
+				decision = '2'
+			} else if d.Winner == 0 {
+				decision = '3'
+			} else {
+				fmt.Fprintf(os.Stderr, "skipping duel %d: winner %d took no part\n", d.ID, d.Winner)
+				skipped = skipped + 1
+				continue
+			}
+			d.Rating1Before = a1.EloRating
+			d.Rating2Before = a2.EloRating
+			d.Rank1Before = rankAmong(artworks, a1)
+			d.Rank2Before = rankAmong(artworks, a2)
+			_, _, err = rate(algorithm, a1, a2, decision)
+			if err != nil {
+				return err
+			}
+			d.Rating1After = a1.EloRating
+			d.Rating2After = a2.EloRating
+			d.Rank1After = rankAmong(artworks, a1)
+			d.Rank2After = rankAmong(artworks, a2)
+			err = tx.UpdateDuel(d)
+			if err != nil {
+				return fmt.Errorf("error updating duel: %s", err)
+			}
+		}
+
+		for _, a := range artworks {
+			err = tx.UpdateArtwork(a)
+			if err != nil {
+				return fmt.Errorf("error updating artwork: %s", err)
+			}
+		}
+
+		fmt.Fprintf(out, "replayed %d duels with %s", len(duels) - skipped - timeouts, algorithm.Name())
+		if timeouts > 0 {
+			fmt.Fprintf(out, ", %d timed out", timeouts)
+		}
+		if skipped > 0 {
+			fmt.Fprintf(out, ", skipped %d", skipped)
+		}
+		fmt.Fprintf(out, "\n\n")
+		printRankDiff(out, artworks, before)
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		fmt.Fprintf(out, "\ndry run, nothing was changed\n")
+		return nil
+	}
+	return err
+}
+
+/* rankAmong works like GetArtworkRank on the artworks being replayed.
+   Artworks that are removed now don't count, even if they were around
+   at the time of the duel. */
+func rankAmong(artworks []*database.Artwork, a *database.Artwork) int64 {
+	var count int64
+	for _, o := range artworks {
+		if !o.DeletedAt.Valid && o.EloRating > a.EloRating && o.Category == a.Category {
+			count = count + 1
+		}
+	}
+	return count + 1
+}
+
+/* printRankDiff lists the artworks that are still in the battle by
+   category and new rank, next to their old rank and rating. */
+func printRankDiff(out io.Writer, artworks []*database.Artwork, before map[uint]float64) {
+	var active []*database.Artwork
+	var old []float64
+	for _, a := range artworks {
+		if !a.DeletedAt.Valid {
+			active = append(active, a)
+			old = append(old, before[a.ID])
+		}
+	}
+	/* same as GetArtworkRank: 1 + the number of better rated artworks
+	   in the same category */
+	rank := func(a *database.Artwork, r float64, all []float64) int {
+		n := 1
+		for i, o := range all {
+			if o > r && active[i].Category == a.Category {
+				n = n + 1
+			}
+		}
+		return n
+	}
+	var now []float64
+	for _, a := range active {
+		now = append(now, a.EloRating)
+	}
+	newRanks := map[uint]int{}
+	oldRanks := map[uint]int{}
+	for _, a := range active {
+		newRanks[a.ID] = rank(a, a.EloRating, now)
+		oldRanks[a.ID] = rank(a, before[a.ID], old)
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		if active[i].Category != active[j].Category {
+			return active[i].Category < active[j].Category
+		}
+		return active[i].EloRating > active[j].EloRating
+	})
+
+	category := ""
+	fmt.Fprintf(out, "%5s %5s %6s %7s %7s  %s\n", "rank", "was", "change", "rating", "was", "artwork")
+	for _, a := range active {
+		if a.Category != category {
+			category = a.Category
+			fmt.Fprintf(out, "[%s]\n", category)
+		}
+		newRank := newRanks[a.ID]
+		oldRank := oldRanks[a.ID]
+		fmt.Fprintf(out, "%5d %5d %+6d %7.1f %7.1f  %s (%s)\n", newRank, oldRank, oldRank - newRank, a.EloRating, before[a.ID], a.Title, a.Artist)
+	}
+}
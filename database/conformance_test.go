@@ -0,0 +1,801 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/* The conformance checks make sure all backends behave the same. Every
+   check gets a fresh, already migrated and empty repository. MySQL is
+   only checked with ARTBATTLE_TEST_MYSQL_DSN set, e.g. to
+   "artbattle:secret@tcp(localhost:3306)/artbattle_test?parseTime=True".
+   All tables in that database are dropped! */
+
+var checks = []struct {
+	name	string
+	check	func(r Repository) error
+}{
+	{"empty", checkEmpty},
+	{"migrations", checkMigrations},
+	{"ids", checkIds},
+	{"lowest duel count", checkLowestDuelCount},
+	{"leaderboard", checkLeaderboard},
+	{"rank", checkRank},
+	{"similar elo rating", checkSimilarEloRating},
+	{"categories", checkCategories},
+	{"filename lookup", checkFilenameLookup},
+	{"remove and restore", checkRemoveAndRestore},
+	{"partial updates", checkPartialUpdates},
+	{"total duel count", checkTotalDuelCount},
+	{"all duels", checkAllDuels},
+	{"duels of artwork", checkDuelsOfArtwork},
+	{"duel details", checkDuelDetails},
+	{"brackets", checkBrackets},
+	{"transaction commit", checkTransactionCommit},
+	{"transaction rollback", checkTransactionRollback},
+}
+
+func TestConformance(t *testing.T) {
+	backends := map[string]func(t *testing.T) Repository{
+		"memory": func(t *testing.T) Repository {
+			return NewMemoryRepository()
+		},
+		"sqlite": func(t *testing.T) Repository {
+			r := &SqliteRepository{}
+			err := r.Open(filepath.Join(t.TempDir(), "check.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return r
+		},
+	}
+	dsn := os.Getenv("ARTBATTLE_TEST_MYSQL_DSN")
+	if dsn != "" {
+		backends["mysql"] = func(t *testing.T) Repository {
+			r := &MysqlRepository{}
+			err := r.Open(dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = r.db.Migrator().DropTable("schema_migrations", "matches", "brackets", "duels", "artworks")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return r
+		}
+	} else {
+		t.Log("ARTBATTLE_TEST_MYSQL_DSN not set, not checking mysql")
+	}
+	for name, newRepo := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, c := range checks {
+				t.Run(c.name, func(t *testing.T) {
+					r := newRepo(t)
+					defer r.Close()
+					err := r.Migrate()
+					if err != nil {
+						t.Fatalf("can't migrate: %v", err)
+					}
+					err = c.check(r)
+					if err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+/* fixture adds artworks with the given ratings and duel counts, in
+   order, and returns them */
+func fixture(r Repository, ratings []float64, duelCounts []uint64) ([]*Artwork, error) {
+	var res []*Artwork
+	for i, rating := range ratings {
+		a := &Artwork{
+			Title: fmt.Sprintf("Artwork %d", i + 1),
+			Artist: fmt.Sprintf("Artist %d", i + 1),
+			Panel: fmt.Sprintf("A%d", i + 1),
+			Filename: fmt.Sprintf("artwork%d.jpg", i + 1),
+			EloRating: rating,
+		}
+		if duelCounts != nil {
+			a.DuelCount = duelCounts[i]
+		}
+		err := r.AddArtwork(a)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+func ids(as []*Artwork) []uint {
+	res := []uint{}
+	for _, a := range as {
+		res = append(res, a.ID)
+	}
+	return res
+}
+
+/* expectIds compares the ids of got against the ids of the fixture
+   entries with the given (0 based) indexes */
+func expectIds(what string, got []*Artwork, fix []*Artwork, indexes ...int) error {
+	want := []uint{}
+	for _, i := range indexes {
+		want = append(want, fix[i].ID)
+	}
+	g := ids(got)
+	if fmt.Sprint(g) != fmt.Sprint(want) {
+		return fmt.Errorf("%s: got ids %v, want %v", what, g, want)
+	}
+	return nil
+}
+
+func checkEmpty(r Repository) error {
+	lowest, err := r.GetArtworksWithLowestDuelCount(Filter{})
+	if err != nil {
+		return err
+	}
+	if len(lowest) != 0 {
+		return fmt.Errorf("GetArtworksWithLowestDuelCount: got %d entries, want 0", len(lowest))
+	}
+	lb, err := r.GetLeaderboard(10, "")
+	if err != nil {
+		return err
+	}
+	if len(lb) != 0 {
+		return fmt.Errorf("GetLeaderboard: got %d entries, want 0", len(lb))
+	}
+	all, err := r.GetAllArtworks()
+	if err != nil {
+		return err
+	}
+	if len(all) != 0 {
+		return fmt.Errorf("GetAllArtworks: got %d entries, want 0", len(all))
+	}
+	count, err := r.GetTotalDuelCount()
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return fmt.Errorf("GetTotalDuelCount: got %d, want 0", count)
+	}
+	return nil
+}
+
+func checkIds(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(fix); i++ {
+		if fix[i].ID <= fix[i-1].ID {
+			return fmt.Errorf("ids not ascending: %v", ids(fix))
+		}
+	}
+	all, err := r.GetAllArtworks()
+	if err != nil {
+		return err
+	}
+	err = expectIds("GetAllArtworks", all, fix, 0, 1, 2)
+	if err != nil {
+		return err
+	}
+	a, err := r.GetArtworkById(int64(fix[1].ID))
+	if err != nil {
+		return err
+	}
+	if a.Title != fix[1].Title || a.Filename != fix[1].Filename {
+		return fmt.Errorf("GetArtworkById: got %q, want %q", a.Title, fix[1].Title)
+	}
+	return nil
+}
+
+func checkLowestDuelCount(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800, 800, 800}, []uint64{3, 1, 2, 1})
+	if err != nil {
+		return err
+	}
+	got, err := r.GetArtworksWithLowestDuelCount(Filter{})
+	if err != nil {
+		return err
+	}
+	err = expectIds("all", got, fix, 1, 3)
+	if err != nil {
+		return err
+	}
+	got, err = r.GetArtworksWithLowestDuelCount(Filter{Exclude: []uint{fix[1].ID, fix[3].ID}})
+	if err != nil {
+		return err
+	}
+	err = expectIds("excluding", got, fix, 2)
+	if err != nil {
+		return err
+	}
+	got, err = r.GetArtworksWithLowestDuelCount(Filter{Exclude: []uint{fix[1].ID}, DuelsBelow: 3})
+	if err != nil {
+		return err
+	}
+	err = expectIds("below 3 duels", got, fix, 3)
+	if err != nil {
+		return err
+	}
+	got, err = r.GetArtworksWithLowestDuelCount(Filter{DuelsBelow: 1})
+	if err != nil {
+		return err
+	}
+	return expectIds("below 1 duel", got, fix)
+}
+
+func checkLeaderboard(r Repository) error {
+	fix, err := fixture(r, []float64{800, 900, 700, 900, 850}, nil)
+	if err != nil {
+		return err
+	}
+	lb, err := r.GetLeaderboard(10, "")
+	if err != nil {
+		return err
+	}
+	err = expectIds("GetLeaderboard(10)", lb, fix, 1, 3, 4, 0, 2)
+	if err != nil {
+		return err
+	}
+	lb, err = r.GetLeaderboard(2, "")
+	if err != nil {
+		return err
+	}
+	return expectIds("GetLeaderboard(2)", lb, fix, 1, 3)
+}
+
+func checkRank(r Repository) error {
+	fix, err := fixture(r, []float64{800, 900, 700, 900}, nil)
+	if err != nil {
+		return err
+	}
+	want := []int64{3, 1, 4, 1}
+	for i, a := range fix {
+		rank, err := r.GetArtworkRank(a)
+		if err != nil {
+			return err
+		}
+		if rank != want[i] {
+			return fmt.Errorf("rank of artwork %d: got %d, want %d", i + 1, rank, want[i])
+		}
+	}
+	return nil
+}
+
+func checkSimilarEloRating(r Repository) error {
+	fix, err := fixture(r, []float64{800, 820, 780, 800, 900, 700, 810, 800}, nil)
+	if err != nil {
+		return err
+	}
+	/* up to 4 higher ones, then at most half of the count of lower or
+	   equal ones, each pushed to the front */
+	got, err := r.GetArtworksWithSimilarEloRating(fix[0], 4, Filter{})
+	if err != nil {
+		return err
+	}
+	err = expectIds("count 4", got, fix, 7, 3, 6, 1)
+	if err != nil {
+		return err
+	}
+	/* few higher ones -> more lower ones */
+	got, err = r.GetArtworksWithSimilarEloRating(fix[4], 4, Filter{})
+	if err != nil {
+		return err
+	}
+	err = expectIds("highest", got, fix, 3, 0, 6, 1)
+	if err != nil {
+		return err
+	}
+	/* more than there are */
+	got, err = r.GetArtworksWithSimilarEloRating(fix[5], 50, Filter{})
+	if err != nil {
+		return err
+	}
+	err = expectIds("count 50", got, fix, 2, 0, 3, 7, 6, 1, 4)
+	if err != nil {
+		return err
+	}
+	/* excluded ones on both sides */
+	got, err = r.GetArtworksWithSimilarEloRating(fix[0], 4, Filter{Exclude: []uint{fix[7].ID, fix[6].ID}})
+	if err != nil {
+		return err
+	}
+	return expectIds("excluding", got, fix, 2, 3, 1, 4)
+}
+
+func checkCategories(r Repository) error {
+	fix, err := fixture(r, []float64{800, 900, 850, 700, 750}, []uint64{1, 0, 2, 3, 1})
+	if err != nil {
+		return err
+	}
+	for _, i := range []int{0, 2, 3} {
+		fix[i].Category = "digital"
+		err = r.UpdateArtworkDetails(fix[i])
+		if err != nil {
+			return err
+		}
+	}
+	digital := Filter{Category: "digital"}
+	got, err := r.GetArtworksWithLowestDuelCount(digital)
+	if err != nil {
+		return err
+	}
+	err = expectIds("GetArtworksWithLowestDuelCount", got, fix, 0)
+	if err != nil {
+		return err
+	}
+	got, err = r.GetArtworksWithSimilarEloRating(fix[0], 4, digital)
+	if err != nil {
+		return err
+	}
+	err = expectIds("GetArtworksWithSimilarEloRating", got, fix, 3, 2)
+	if err != nil {
+		return err
+	}
+	lb, err := r.GetLeaderboard(10, "digital")
+	if err != nil {
+		return err
+	}
+	err = expectIds("GetLeaderboard", lb, fix, 2, 0, 3)
+	if err != nil {
+		return err
+	}
+	/* ranks count within the category */
+	want := []int64{2, 1, 1, 3, 2}
+	for i, a := range fix {
+		rank, err := r.GetArtworkRank(a)
+		if err != nil {
+			return err
+		}
+		if rank != want[i] {
+			return fmt.Errorf("rank of artwork %d: got %d, want %d", i + 1, rank, want[i])
+		}
+	}
+	return nil
+}
+
+func checkFilenameLookup(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	a, err := r.GetArtworkByFilename(fix[1].Filename)
+	if err != nil {
+		return err
+	}
+	if a == nil || a.ID != fix[1].ID {
+		return fmt.Errorf("GetArtworkByFilename: got %v, want id %d", a, fix[1].ID)
+	}
+	a, err = r.GetArtworkByFilename("does-not-exist.jpg")
+	if err != nil || a != nil {
+		return fmt.Errorf("GetArtworkByFilename of missing file: got %v, %v, want nil, nil", a, err)
+	}
+	return nil
+}
+
+func checkRemoveAndRestore(r Repository) error {
+	fix, err := fixture(r, []float64{900, 800, 700}, []uint64{0, 1, 1})
+	if err != nil {
+		return err
+	}
+	err = r.RemoveArtwork(fix[0])
+	if err != nil {
+		return err
+	}
+	lb, err := r.GetLeaderboard(10, "")
+	if err != nil {
+		return err
+	}
+	err = expectIds("GetLeaderboard after remove", lb, fix, 1, 2)
+	if err != nil {
+		return err
+	}
+	rank, err := r.GetArtworkRank(fix[1])
+	if err != nil {
+		return err
+	}
+	if rank != 1 {
+		return fmt.Errorf("rank after remove: got %d, want 1", rank)
+	}
+	lowest, err := r.GetArtworksWithLowestDuelCount(Filter{})
+	if err != nil {
+		return err
+	}
+	err = expectIds("GetArtworksWithLowestDuelCount after remove", lowest, fix, 1, 2)
+	if err != nil {
+		return err
+	}
+	a, err := r.GetArtworkByFilename(fix[0].Filename)
+	if err != nil || a != nil {
+		return fmt.Errorf("GetArtworkByFilename after remove: got %v, %v, want nil, nil", a, err)
+	}
+	a, err = r.GetRemovedArtworkByFilename(fix[0].Filename)
+	if err != nil {
+		return err
+	}
+	if a == nil || a.ID != fix[0].ID {
+		return fmt.Errorf("GetRemovedArtworkByFilename: got %v, want id %d", a, fix[0].ID)
+	}
+	err = r.RestoreArtwork(a)
+	if err != nil {
+		return err
+	}
+	lb, err = r.GetLeaderboard(10, "")
+	if err != nil {
+		return err
+	}
+	return expectIds("GetLeaderboard after restore", lb, fix, 0, 1, 2)
+}
+
+/* checkPartialUpdates makes sure a vote doesn't undo what the image
+   watcher did during the duel, and the other way round */
+func checkPartialUpdates(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	duelist := *fix[0]
+	/* the watcher renames one artwork and retires the other */
+	fix[0].Title = "Renamed"
+	err = r.UpdateArtworkDetails(fix[0])
+	if err != nil {
+		return err
+	}
+	err = r.RemoveArtwork(fix[1])
+	if err != nil {
+		return err
+	}
+	retired := *fix[1]
+	retired.DeletedAt = gorm.DeletedAt{}
+	/* the vote comes in with the artworks as they were */
+	duelist.EloRating, duelist.DuelCount = 816, 1
+	retired.EloRating, retired.DuelCount = 784, 1
+	err = r.Transaction(func(tx Tx) error {
+		err := tx.UpdateRatings(&duelist)
+		if err != nil {
+			return err
+		}
+		return tx.UpdateRatings(&retired)
+	})
+	if err != nil {
+		return err
+	}
+	a, err := r.GetArtworkById(int64(fix[0].ID))
+	if err != nil {
+		return err
+	}
+	if a.Title != "Renamed" || a.EloRating != 816 || a.DuelCount != 1 {
+		return fmt.Errorf("renamed: got %q, rating %v, %d duels, want Renamed, 816, 1", a.Title, a.EloRating, a.DuelCount)
+	}
+	all, err := r.GetAllArtworks()
+	if err != nil {
+		return err
+	}
+	err = expectIds("retired", all, fix, 0)
+	if err != nil {
+		return err
+	}
+	var removed *Artwork
+	err = r.Transaction(func(tx Tx) error {
+		all, err := tx.GetAllArtworksWithRemoved()
+		removed = all[1]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if removed.EloRating != 784 {
+		return fmt.Errorf("retired: got rating %v, want 784", removed.EloRating)
+	}
+	/* and the watcher doesn't touch the rating */
+	a.Title = "Renamed again"
+	a.EloRating = 800
+	err = r.UpdateArtworkDetails(a)
+	if err != nil {
+		return err
+	}
+	a, err = r.GetArtworkById(int64(fix[0].ID))
+	if err != nil {
+		return err
+	}
+	if a.Title != "Renamed again" || a.EloRating != 816 {
+		return fmt.Errorf("renamed again: got %q, rating %v, want Renamed again, 816", a.Title, a.EloRating)
+	}
+	return nil
+}
+
+func checkTotalDuelCount(r Repository) error {
+	_, err := fixture(r, []float64{800, 800, 800}, []uint64{3, 2, 1})
+	if err != nil {
+		return err
+	}
+	count, err := r.GetTotalDuelCount()
+	if err != nil {
+		return err
+	}
+	if count != 3 {
+		return fmt.Errorf("got %d, want 3", count)
+	}
+	return nil
+}
+
+func checkAllDuels(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	t := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	/* logged out of order, and two at the same time */
+	when := []time.Time{t.Add(time.Minute), t, t}
+	for _, w := range when {
+		err = r.AddDuel(&Duel{Duelist1: fix[0].ID, Duelist2: fix[1].ID, Winner: fix[0].ID, When: w})
+		if err != nil {
+			return err
+		}
+	}
+	err = r.RemoveArtwork(fix[1])
+	if err != nil {
+		return err
+	}
+	var duels []*Duel
+	err = r.Transaction(func(tx Tx) error {
+		all, err := tx.GetAllArtworksWithRemoved()
+		if err != nil {
+			return err
+		}
+		err = expectIds("GetAllArtworksWithRemoved", all, fix, 0, 1)
+		if err != nil {
+			return err
+		}
+		duels, err = tx.GetAllDuels()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	got := []uint{}
+	for _, d := range duels {
+		got = append(got, d.ID)
+	}
+	if len(got) != 3 || got[0] >= got[1] || duels[2].When.Before(duels[1].When) || !duels[0].When.Equal(t) {
+		return fmt.Errorf("GetAllDuels: got ids %v, want the two duels at the same time first, ordered by id", got)
+	}
+	return nil
+}
+
+func checkDuelsOfArtwork(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	t := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	pairs := [][2]int{{0, 1}, {1, 2}, {2, 0}}
+	for i, p := range pairs {
+		err = r.AddDuel(&Duel{Duelist1: fix[p[0]].ID, Duelist2: fix[p[1]].ID, Winner: fix[p[0]].ID, When: t.Add(time.Duration(-i) * time.Minute)})
+		if err != nil {
+			return err
+		}
+	}
+	duels, err := r.GetDuelsOfArtwork(fix[0].ID)
+	if err != nil {
+		return err
+	}
+	if len(duels) != 2 || duels[0].Duelist1 != fix[2].ID || duels[1].Duelist1 != fix[0].ID {
+		return fmt.Errorf("got %d duels, want the two with artwork 1, oldest first", len(duels))
+	}
+	/* the stored ratings can be rewritten */
+	duels[0].Rank1After = 2
+	err = r.Transaction(func(tx Tx) error {
+		return tx.UpdateDuel(duels[0])
+	})
+	if err != nil {
+		return err
+	}
+	duels, err = r.GetDuelsOfArtwork(fix[0].ID)
+	if err != nil {
+		return err
+	}
+	if duels[0].Rank1After != 2 {
+		return fmt.Errorf("UpdateDuel: got rank %d, want 2", duels[0].Rank1After)
+	}
+	return nil
+}
+
+func checkBrackets(r Repository) error {
+	b, err := r.GetCurrentBracket()
+	if err != nil || b != nil {
+		return fmt.Errorf("GetCurrentBracket without brackets: got %v, %v, want nil, nil", b, err)
+	}
+	for i := 0; i < 2; i++ {
+		b = &Bracket{Format: "single", BestOf: 3, Seeds: "2,1"}
+		matches := []*Match{
+			{Number: 1, Round: "W2", WinnerTo: -1, LoserTo: -1},
+			{Number: 0, Round: "W1", Artwork1: 2, Artwork2: 1, WinnerTo: 1, WinnerSlot: 1, LoserTo: -1},
+		}
+		err = r.AddBracket(b, matches)
+		if err != nil {
+			return err
+		}
+	}
+	current, err := r.GetCurrentBracket()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != b.ID {
+		return fmt.Errorf("GetCurrentBracket: got %v, want id %d", current, b.ID)
+	}
+	err = r.Transaction(func(tx Tx) error {
+		matches, err := tx.GetMatches(b.ID)
+		if err != nil {
+			return err
+		}
+		if len(matches) != 2 || matches[0].Number != 0 || matches[0].BracketID != b.ID {
+			return fmt.Errorf("GetMatches: got %d matches, want 2 of bracket %d by number", len(matches), b.ID)
+		}
+		matches[0].Wins1 = 2
+		matches[0].Winner = 2
+		err = tx.UpdateMatch(matches[0])
+		if err != nil {
+			return err
+		}
+		b.Champion = 2
+		return tx.UpdateBracket(b)
+	})
+	if err != nil {
+		return err
+	}
+	matches, err := r.GetMatches(b.ID)
+	if err != nil {
+		return err
+	}
+	if matches[0].Winner != 2 || matches[0].Wins1 != 2 || matches[1].WinnerTo != -1 {
+		return fmt.Errorf("after UpdateMatch: got %+v", matches[0])
+	}
+	err = r.RemoveBracket(b)
+	if err != nil {
+		return err
+	}
+	current, err = r.GetCurrentBracket()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID == b.ID || current.Champion != 0 {
+		return fmt.Errorf("GetCurrentBracket after remove: got %v, want the first bracket", current)
+	}
+	return nil
+}
+
+/* checkDuelDetails stores a vote and a timeout with all details */
+func checkDuelDetails(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	t := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	shown := t.Add(-4 * time.Second)
+	want := []*Duel{
+		{Duelist1: fix[0].ID, Duelist2: fix[1].ID, Winner: fix[1].ID, When: t, ShownAt: &shown, DecisionMs: 4000, Source: "serial", Kiosk: "foyer"},
+		{Duelist1: fix[1].ID, Duelist2: fix[0].ID, When: t.Add(time.Minute), Kiosk: "foyer", TimedOut: true},
+	}
+	for _, d := range want {
+		err = r.AddDuel(d)
+		if err != nil {
+			return err
+		}
+	}
+	got, err := r.GetDuelsOfArtwork(fix[0].ID)
+	if err != nil {
+		return err
+	}
+	if len(got) != 2 {
+		return fmt.Errorf("got %d duels, want 2", len(got))
+	}
+	if got[0].ShownAt == nil || !got[0].ShownAt.Equal(shown) || got[0].DecisionMs != 4000 || got[0].Source != "serial" || got[0].Kiosk != "foyer" || got[0].TimedOut {
+		return fmt.Errorf("vote: got shown %v, %dms, source %q, kiosk %q, timed out %v", got[0].ShownAt, got[0].DecisionMs, got[0].Source, got[0].Kiosk, got[0].TimedOut)
+	}
+	if got[1].ShownAt != nil || !got[1].TimedOut || got[1].Winner != 0 {
+		return fmt.Errorf("timeout: got shown %v, timed out %v, winner %d", got[1].ShownAt, got[1].TimedOut, got[1].Winner)
+	}
+	return nil
+}
+
+/* checkMigrations expects all schema steps applied, and applying them
+   again changes nothing */
+func checkMigrations(r Repository) error {
+	for i := 0; i < 2; i++ {
+		err := r.Migrate()
+		if err != nil {
+			return err
+		}
+	}
+	states, err := r.Migrations()
+	if err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		return fmt.Errorf("no schema steps")
+	}
+	for i, s := range states {
+		if !s.Applied || s.Name == "" {
+			return fmt.Errorf("step %d: got applied %v, name %q, want applied and known", s.Version, s.Applied, s.Name)
+		}
+		if i > 0 && s.Version <= states[i - 1].Version {
+			return fmt.Errorf("steps out of order: %d after %d", s.Version, states[i - 1].Version)
+		}
+	}
+	if CurrentVersion(states) != SchemaVersion() {
+		return fmt.Errorf("got version %d, want %d", CurrentVersion(states), SchemaVersion())
+	}
+	return nil
+}
+
+func checkTransactionCommit(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	err = r.Transaction(func(tx Tx) error {
+		fix[0].EloRating = 816
+		fix[0].DuelCount = 1
+		err := tx.UpdateRatings(fix[0])
+		if err != nil {
+			return err
+		}
+		rank, err := tx.GetArtworkRank(fix[1])
+		if err != nil {
+			return err
+		}
+		if rank != 2 {
+			return fmt.Errorf("rank inside transaction: got %d, want 2", rank)
+		}
+		return tx.AddDuel(&Duel{Duelist1: fix[0].ID, Duelist2: fix[1].ID, Winner: fix[0].ID})
+	})
+	if err != nil {
+		return err
+	}
+	a, err := r.GetArtworkById(int64(fix[0].ID))
+	if err != nil {
+		return err
+	}
+	if a.EloRating != 816 || a.DuelCount != 1 {
+		return fmt.Errorf("after commit: got rating %v, %d duels, want 816, 1", a.EloRating, a.DuelCount)
+	}
+	return nil
+}
+
+func checkTransactionRollback(r Repository) error {
+	fix, err := fixture(r, []float64{800, 800}, nil)
+	if err != nil {
+		return err
+	}
+	failure := errors.New("failure")
+	err = r.Transaction(func(tx Tx) error {
+		fix[0].EloRating = 816
+		err := tx.UpdateRatings(fix[0])
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		return fmt.Errorf("got error %v, want %v", err, failure)
+	}
+	a, err := r.GetArtworkById(int64(fix[0].ID))
+	if err != nil {
+		return err
+	}
+	if a.EloRating != 800 {
+		return fmt.Errorf("after rollback: got rating %v, want 800", a.EloRating)
+	}
+	return nil
+}
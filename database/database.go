@@ -1,11 +1,10 @@
 package database
 
 import (
-	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
-	"gorm.io/driver/mysql"
 )
 
 type Artwork struct {
@@ -13,208 +12,174 @@ type Artwork struct {
 	Title		string	`gorm:"type:varchar(120); NOT NULL"`
 	Artist		string	`gorm:"type:varchar(120); NOT NULL"`
 	Panel		string	`gorm:"type:varchar(10); NOT NULL"`
+	/* duels are only drawn within a category */
+	Category	string	`gorm:"type:varchar(40); NOT NULL; default:''; index:idx_category"`
 	Filename	string	`gorm:"type:varchar(120); NOT NULL"`
 	Thumbnail	string	`gorm:"type:varchar(120); NOT NULL"`
+	ScreenImage	string	`gorm:"type:varchar(120); NOT NULL; default:''"`
 	DuelCount	uint64	`gorm:"index:idx_duel_count"`
-	EloRating	int16	`gorm:"index:idx_elo_rating"`
+	EloRating	float64	`gorm:"index:idx_elo_rating"`
+	/* only used by Glicko-2 */
+	RatingDeviation		float64	`gorm:"NOT NULL; default:350"`
+	RatingVolatility	float64	`gorm:"NOT NULL; default:0.06"`
 }
 
 type Duel struct {
 	gorm.Model
 	Duelist1	uint		`gorm:"type:bigint; NOT NULL"`
 	Duelist2	uint		`gorm:"type:bigint; NOT NULL"`
+	/* 0 is a draw */
 	Winner		uint		`gorm:"type:bigint; NOT NULL"`
 	When		time.Time	`gorm:"NOT NULL"`
-}
-
-type MysqlRepository struct {
-	db	*gorm.DB
-}
-
-var _db *MysqlRepository
-
-func GetDB() (*MysqlRepository, error) {
+	/* ratings and leaderboard ranks of both duelists around the duel.
+	   Ranks are 0 for duels recorded before these were kept. */
+	Rating1Before	float64		`gorm:"NOT NULL; default:0"`
+	Rating1After	float64		`gorm:"NOT NULL; default:0"`
+	Rating2Before	float64		`gorm:"NOT NULL; default:0"`
+	Rating2After	float64		`gorm:"NOT NULL; default:0"`
+	Rank1Before	int64		`gorm:"NOT NULL; default:0"`
+	Rank1After	int64		`gorm:"NOT NULL; default:0"`
+	Rank2Before	int64		`gorm:"NOT NULL; default:0"`
+	Rank2After	int64		`gorm:"NOT NULL; default:0"`
+	/* when the duel was shown, nil for duels recorded before this was
+	   kept, and how long it took until the vote, 0 without one */
+	ShownAt		*time.Time
+	DecisionMs	int64		`gorm:"NOT NULL; default:0"`
+	/* the input source of the vote, e.g. serial or websocket */
+	Source		string		`gorm:"type:varchar(20); NOT NULL; default:''"`
+	/* the kiosk the duel was shown on, see server.kiosk */
+	Kiosk		string		`gorm:"type:varchar(40); NOT NULL; default:''"`
+	/* nobody voted. Winner is 0 and the ratings didn't change. */
+	TimedOut	bool		`gorm:"NOT NULL; default:false"`
+}
+
+/* Bracket is a finals tournament among the best artworks at the time
+   it started. */
+type Bracket struct {
+	gorm.Model
+	/* single or double elimination */
+	Format		string		`gorm:"type:varchar(10); NOT NULL"`
+	/* every match is a best of this many votes */
+	BestOf		int		`gorm:"NOT NULL"`
+	Category	string		`gorm:"type:varchar(40); NOT NULL; default:''"`
+	/* artwork ids by seed, best first, separated by commas */
+	Seeds		string		`gorm:"type:text; NOT NULL"`
+	/* 0 while the tournament runs */
+	Champion	uint		`gorm:"NOT NULL; default:0"`
+}
+
+/* Match is one series of votes between two artworks of a bracket. */
+type Match struct {
+	gorm.Model
+	BracketID	uint		`gorm:"NOT NULL; index:idx_bracket"`
+	/* position in the bracket, matches are played in this order */
+	Number		int		`gorm:"NOT NULL"`
+	/* e.g. W1 for the first round of the winners bracket */
+	Round		string		`gorm:"type:varchar(4); NOT NULL"`
+	/* 0 while not known yet */
+	Artwork1	uint		`gorm:"NOT NULL; default:0"`
+	Artwork2	uint		`gorm:"NOT NULL; default:0"`
+	Wins1		int		`gorm:"NOT NULL; default:0"`
+	Wins2		int		`gorm:"NOT NULL; default:0"`
+	/* 0 while undecided */
+	Winner		uint		`gorm:"NOT NULL; default:0"`
+	/* number and slot (1 or 2) of the matches the winner and the
+	   loser go on to, -1 if they don't */
+	WinnerTo	int		`gorm:"NOT NULL"`
+	WinnerSlot	int		`gorm:"NOT NULL"`
+	LoserTo		int		`gorm:"NOT NULL"`
+	LoserSlot	int		`gorm:"NOT NULL"`
+}
+
+/* Filter narrows down the artworks a query considers. Category "" means
+   any category, Exclude lists artwork ids to skip, and with DuelsBelow
+   set, only artworks with fewer duels count. The zero Filter considers
+   all artworks. */
+type Filter struct {
+	Category	string
+	Exclude		[]uint
+	DuelsBelow	uint64
+}
+
+/* Tx is the subset of repository operations that processDecision and
+   the rating recomputation need inside a transaction. */
+type Tx interface {
+	/* the rank within the category of the artwork */
+	GetArtworkRank(a *Artwork) (int64, error)
+	/* writes the rating and duel count of an artwork, removed ones too,
+	   and nothing else. The image watcher may have changed the rest
+	   meanwhile. */
+	UpdateRatings(a *Artwork) error
+	/* writes the whole artwork, removed ones too. Only for replaying
+	   the duel log. */
+	UpdateArtwork(a *Artwork) error
+	AddDuel(d *Duel) error
+	UpdateDuel(d *Duel) error
+	/* ordered by id, including removed artworks */
+	GetAllArtworksWithRemoved() ([]*Artwork, error)
+	/* ordered by when, then id */
+	GetAllDuels() ([]*Duel, error)
+	UpdateBracket(b *Bracket) error
+	/* ordered by number */
+	GetMatches(bracketId uint) ([]*Match, error)
+	UpdateMatch(m *Match) error
+}
+
+/* Repository is implemented by every database backend. */
+type Repository interface {
+	Tx
+
+	Open(dsn string) error
+	Close()
+	/* applies all schema steps not applied yet, ErrSchemaTooNew if the
+	   database has steps this program doesn't know */
+	Migrate() error
+	Migrations() ([]MigrationState, error)
+	Transaction(tx func (Tx) error) error
+
+	AddArtwork(a *Artwork) error
+	RemoveArtwork(a *Artwork) error
+	RestoreArtwork(a *Artwork) error
+	/* writes what the image file says about an artwork: title, artist,
+	   panel, category and the generated images, but not the rating */
+	UpdateArtworkDetails(a *Artwork) error
+	GetArtworkById(id int64) (*Artwork, error)
+	GetArtworkByFilename(filename string) (*Artwork, error)
+	GetRemovedArtworkByFilename(filename string) (*Artwork, error)
+	GetAllArtworks() ([]*Artwork, error)
+	/* all artworks that share the lowest duel count, ordered by id */
+	GetArtworksWithLowestDuelCount(f Filter) ([]*Artwork, error)
+	/* category "" means all categories */
+	GetLeaderboard(maxcount int, category string) ([]*Artwork, error)
+	GetArtworksWithSimilarEloRating(benchmark *Artwork, count int, f Filter) ([]*Artwork, error)
+	GetTotalDuelCount() (int64, error)
+	/* ordered by when, then id */
+	GetDuelsOfArtwork(id uint) ([]*Duel, error)
+	/* stores a new bracket together with its matches */
+	AddBracket(b *Bracket, matches []*Match) error
+	/* the latest bracket that wasn't removed, nil if there is none */
+	GetCurrentBracket() (*Bracket, error)
+	RemoveBracket(b *Bracket) error
+}
+
+var _db Repository
+
+func GetDB() (Repository, error) {
 	return _db, nil
 }
 
-func Create() *MysqlRepository {
-	_db = &MysqlRepository{}
-	return _db
-}
-
-func (r *MysqlRepository) Open(dsn string) error {
-	gormConfig := &gorm.Config{}
-	db, err := gorm.Open(mysql.Open(dsn), gormConfig)
-	if err != nil {
-		return err
-	}
-	r.db = db
-	return nil
-}
-
-func (r *MysqlRepository) Close() {
-	_db = nil
-	// no-op in Gorm v2
-}
-
-func (r *MysqlRepository) Migrate() error {
-	err := r.db.AutoMigrate(
-		&Artwork{},
-		&Duel{},
-	)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (r *MysqlRepository) Transaction(tx func (*gorm.DB) error) error {
-	return r.db.Transaction(tx)
-}
-
-func (r *MysqlRepository) AddArtwork(a *Artwork) error {
-	err := r.db.Create(a).Error
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (r *MysqlRepository) RemoveArtwork(a *Artwork) error {
-	err := r.db.Delete(a).Error
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (r *MysqlRepository) GetArtworkById(id int64) (*Artwork, error) {
-	var a Artwork
-	err := r.db.First(&a, id).Error
-	if err != nil {
-		return nil, err
-	}
-	return &a, nil
-}
-
-func (r *MysqlRepository) GetArtworkByFilename(filename string) (*Artwork, error) {
-	var a Artwork
-	result := r.db.Where("filename = ?", filename).First(&a)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, nil
-		}
-		return nil, result.Error
-	}
-	return &a, nil
-}
-
-func (r *MysqlRepository) GetArtworkWithLowestDuelCount() (*Artwork, error) {
-	var a Artwork
-	err := r.db.Order("duel_count asc").Limit(1).First(&a).Error
-	if err != nil {
-		return nil, err
-	}
-	return &a, nil
-}
-
-func (r *MysqlRepository) GetLeaderboard(maxcount int) ([]*Artwork, error) {
-	var lb []*Artwork
-	rows, err := r.db.Table("artworks").Order("elo_rating desc, id asc").Limit(maxcount).Rows()
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var a Artwork
-		r.db.ScanRows(rows, &a)
-		lb = append(lb, &a)
-	}
-	return lb, nil
-}
-
-func (r *MysqlRepository) GetArtworksWithSimilarEloRating(benchmark *Artwork, count int) ([]*Artwork, error) {
-	/* step 1: load up to 'count' artworks with elo higher than benchmark
-	   step 2: load an approriate number of artworks with lower or
-	   	   equal elo. Push out higher ones with lower ones */
-	var res []*Artwork
-	rows, err := r.db.Model(&Artwork{}).Where("elo_rating > ?", benchmark.EloRating).Order("elo_rating asc").Limit(count).Rows()
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var lc int = 0
-	for rows.Next() {
-		var a Artwork
-		r.db.ScanRows(rows, &a)
-		res = append(res, &a)
-		lc = lc + 1
-	}
-	/* how many more rows do we want? */
-	var remaining_count int
-	if lc < (count / 2) {
-		remaining_count = count - lc
-	} else {
-		remaining_count = (count / 2)
-	}
-	rows2, err := r.db.Model(&Artwork{}).Where("elo_rating <= ? and id != ?", benchmark.EloRating, benchmark.ID).Order("elo_rating desc").Limit(remaining_count).Rows()
-	if err != nil {
-		return nil, err
-	}
-	defer rows2.Close()
-	for rows2.Next() {
-		var a Artwork
-		r.db.ScanRows(rows2, &a)
-		lc = lc + 1
-		res = append([]*Artwork{&a}, res...)
-	}
-	if len(res) < count {
-		count = len(res)
-	}
-	return res[:count], nil
-}
-
-func (r *MysqlRepository) UpdateArtwork(a *Artwork) error {
-	err := r.db.Save(a).Error
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func UpdateArtwork(db *gorm.DB, a *Artwork) error {
-	err := db.Save(a).Error
-	if err != nil {
-		return err
+/* Create sets up the repository for the given database.driver. Call Open
+   on the result to connect. */
+func Create(driver string) (Repository, error) {
+	switch driver {
+	case "mysql":
+		_db = &MysqlRepository{}
+	case "sqlite":
+		_db = &SqliteRepository{}
+	case "memory":
+		_db = NewMemoryRepository()
+	default:
+		return nil, fmt.Errorf("unknown database driver: %s", driver)
 	}
-	return nil
-}
-
-func (r *MysqlRepository) GetArtworkRank(a *Artwork) (int64, error) {
-	var count int64
-	r.db.Model(&Artwork{}).Where("elo_rating > ?", a.EloRating).Count(&count)
-	return count + 1, nil
-}
-
-func GetArtworkRank(db *gorm.DB, a *Artwork) (int64, error) {
-	var count int64
-	db.Model(&Artwork{}).Where("elo_rating > ?", a.EloRating).Count(&count)
-	return count + 1, nil
-}
-
-func (r *MysqlRepository) GetTotalDuelCount() (int64, error) {
-	var count int64
-	r.db.Table("artworks").Select("sum(duel_count)").Row().Scan(&count)
-	/* the total number of duels is half the sum of all duel_counts because a duel
-	   has two participants. (hence the name) */
-	return count / 2, nil
-}
-
-func AddDuel(db *gorm.DB, d *Duel) error {
-	err := db.Create(d).Error
-	if err != nil {
-		return err
-	}
-	return nil
+	return _db, nil
 }
-
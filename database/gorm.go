@@ -0,0 +1,325 @@
+package database
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+/* gormRepository has the queries shared by all gorm based backends. */
+type gormRepository struct {
+	db	*gorm.DB
+}
+
+func (r *gormRepository) Close() {
+	_db = nil
+	// no-op in Gorm v2
+}
+
+func (r *gormRepository) Transaction(tx func (Tx) error) error {
+	return r.db.Transaction(func(db *gorm.DB) error {
+		return tx(&gormRepository{db: db})
+	})
+}
+
+func (r *gormRepository) AddArtwork(a *Artwork) error {
+	err := r.db.Create(a).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) RemoveArtwork(a *Artwork) error {
+	err := r.db.Delete(a).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) GetArtworkById(id int64) (*Artwork, error) {
+	var a Artwork
+	err := r.db.First(&a, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (r *gormRepository) GetArtworkByFilename(filename string) (*Artwork, error) {
+	var a Artwork
+	result := r.db.Where("filename = ?", filename).First(&a)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &a, nil
+}
+
+/* GetRemovedArtworkByFilename finds an artwork that was removed earlier,
+   e.g. because its file disappeared for a while. */
+func (r *gormRepository) GetRemovedArtworkByFilename(filename string) (*Artwork, error) {
+	var a Artwork
+	result := r.db.Unscoped().Where("filename = ? and deleted_at is not null", filename).First(&a)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &a, nil
+}
+
+func (r *gormRepository) RestoreArtwork(a *Artwork) error {
+	err := r.db.Unscoped().Model(a).Update("deleted_at", nil).Error
+	if err != nil {
+		return err
+	}
+	a.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (r *gormRepository) GetAllArtworks() ([]*Artwork, error) {
+	var all []*Artwork
+	err := r.db.Order("id asc").Find(&all).Error
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+/* filtered narrows a query down to the artworks f considers */
+func filtered(db *gorm.DB, f Filter) *gorm.DB {
+	if f.Category != "" {
+		db = db.Where("category = ?", f.Category)
+	}
+	if f.DuelsBelow > 0 {
+		db = db.Where("duel_count < ?", f.DuelsBelow)
+	}
+	/* "not in ()" isn't valid SQL */
+	if len(f.Exclude) > 0 {
+		db = db.Where("id not in ?", f.Exclude)
+	}
+	return db
+}
+
+func (r *gormRepository) GetArtworksWithLowestDuelCount(f Filter) ([]*Artwork, error) {
+	var lowest []*Artwork
+	err := filtered(r.db, f).Order("duel_count asc").Limit(1).Find(&lowest).Error
+	if err != nil || len(lowest) == 0 {
+		return lowest, err
+	}
+	var all []*Artwork
+	err = filtered(r.db, f).Where("duel_count = ?", lowest[0].DuelCount).Order("id asc").Find(&all).Error
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (r *gormRepository) GetLeaderboard(maxcount int, category string) ([]*Artwork, error) {
+	var lb []*Artwork
+	rows, err := filtered(r.db.Model(&Artwork{}), Filter{Category: category}).Order("elo_rating desc, id asc").Limit(maxcount).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Artwork
+		r.db.ScanRows(rows, &a)
+		lb = append(lb, &a)
+	}
+	return lb, nil
+}
+
+func (r *gormRepository) GetArtworksWithSimilarEloRating(benchmark *Artwork, count int, f Filter) ([]*Artwork, error) {
+	/* step 1: load up to 'count' artworks with elo higher than benchmark
+	   step 2: load an approriate number of artworks with lower or
+	   	   equal elo. Push out higher ones with lower ones */
+	var res []*Artwork
+	rows, err := filtered(r.db.Model(&Artwork{}), f).Where("elo_rating > ?", benchmark.EloRating).Order("elo_rating asc, id asc").Limit(count).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lc int = 0
+	for rows.Next() {
+		var a Artwork
+		r.db.ScanRows(rows, &a)
+		res = append(res, &a)
+		lc = lc + 1
+	}
+	/* how many more rows do we want? */
+	var remaining_count int
+	if lc < (count / 2) {
+		remaining_count = count - lc
+	} else {
+		remaining_count = (count / 2)
+	}
+	rows2, err := filtered(r.db.Model(&Artwork{}), f).Where("elo_rating <= ? and id != ?", benchmark.EloRating, benchmark.ID).Order("elo_rating desc, id asc").Limit(remaining_count).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows2.Close()
+	for rows2.Next() {
+		var a Artwork
+		r.db.ScanRows(rows2, &a)
+		lc = lc + 1
+		res = append([]*Artwork{&a}, res...)
+	}
+	if len(res) < count {
+		count = len(res)
+	}
+	return res[:count], nil
+}
+
+func (r *gormRepository) GetAllArtworksWithRemoved() ([]*Artwork, error) {
+	var all []*Artwork
+	err := r.db.Unscoped().Order("id asc").Find(&all).Error
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+/* UpdateArtwork works on removed artworks too, their ratings still
+   change when the duel history is replayed. */
+func (r *gormRepository) UpdateArtwork(a *Artwork) error {
+	err := r.db.Unscoped().Save(a).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+/* UpdateRatings leaves deleted_at alone, an artwork retired during its
+   duel stays retired. */
+func (r *gormRepository) UpdateRatings(a *Artwork) error {
+	err := r.db.Unscoped().Model(a).Select("elo_rating", "rating_deviation", "rating_volatility", "duel_count").Updates(a).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) UpdateArtworkDetails(a *Artwork) error {
+	err := r.db.Model(a).Select("title", "artist", "panel", "category", "thumbnail", "screen_image").Updates(a).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) GetArtworkRank(a *Artwork) (int64, error) {
+	var count int64
+	r.db.Model(&Artwork{}).Where("elo_rating > ? and category = ?", a.EloRating, a.Category).Count(&count)
+	return count + 1, nil
+}
+
+func (r *gormRepository) GetTotalDuelCount() (int64, error) {
+	var count int64
+	r.db.Table("artworks").Select("sum(duel_count)").Row().Scan(&count)
+	/* the total number of duels is half the sum of all duel_counts because a duel
+	   has two participants. (hence the name) */
+	return count / 2, nil
+}
+
+func (r *gormRepository) UpdateDuel(d *Duel) error {
+	err := r.db.Save(d).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) GetDuelsOfArtwork(id uint) ([]*Duel, error) {
+	var duels []*Duel
+	err := r.db.Where("duelist1 = ? or duelist2 = ?", id, id).Order("`when` asc, id asc").Find(&duels).Error
+	if err != nil {
+		return nil, err
+	}
+	return duels, nil
+}
+
+func (r *gormRepository) GetAllDuels() ([]*Duel, error) {
+	var all []*Duel
+	err := r.db.Order("`when` asc, id asc").Find(&all).Error
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (r *gormRepository) AddDuel(d *Duel) error {
+	err := r.db.Create(d).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+func (r *gormRepository) AddBracket(b *Bracket, matches []*Match) error {
+	return r.db.Transaction(func(db *gorm.DB) error {
+		err := db.Create(b).Error
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			m.BracketID = b.ID
+			err = db.Create(m).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *gormRepository) GetCurrentBracket() (*Bracket, error) {
+	var b Bracket
+	err := r.db.Order("id desc").First(&b).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (r *gormRepository) RemoveBracket(b *Bracket) error {
+	err := r.db.Delete(b).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) UpdateBracket(b *Bracket) error {
+	err := r.db.Save(b).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *gormRepository) GetMatches(bracketId uint) ([]*Match, error) {
+	var matches []*Match
+	err := r.db.Where("bracket_id = ?", bracketId).Order("number asc").Find(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+func (r *gormRepository) UpdateMatch(m *Match) error {
+	err := r.db.Save(m).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
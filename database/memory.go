@@ -0,0 +1,576 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/* MemoryRepository keeps everything in memory and forgets it on exit.
+   It mimics the semantics of the SQL queries, with ties always broken
+   by id, so it can be used for tests and simulations. */
+type MemoryRepository struct {
+	lock	sync.Mutex
+	store	*memoryStore
+	/* there is no schema, but the steps are recorded as applied */
+	migrated	time.Time
+}
+
+type memoryStore struct {
+	artworks	map[uint]*Artwork
+	duels		[]*Duel
+	brackets	[]*Bracket
+	matches		[]*Match
+	nextArtwork	uint
+	nextDuel	uint
+	nextBracket	uint
+	nextMatch	uint
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{store: newMemoryStore()}
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		artworks: map[uint]*Artwork{},
+		nextArtwork: 1,
+		nextDuel: 1,
+		nextBracket: 1,
+		nextMatch: 1,
+	}
+}
+
+func (s *memoryStore) clone() *memoryStore {
+	c := &memoryStore{
+		artworks: make(map[uint]*Artwork, len(s.artworks)),
+		duels: make([]*Duel, len(s.duels)),
+		brackets: make([]*Bracket, len(s.brackets)),
+		matches: make([]*Match, len(s.matches)),
+		nextArtwork: s.nextArtwork,
+		nextDuel: s.nextDuel,
+		nextBracket: s.nextBracket,
+		nextMatch: s.nextMatch,
+	}
+	for id, a := range s.artworks {
+		dup := *a
+		c.artworks[id] = &dup
+	}
+	for i, d := range s.duels {
+		dup := *d
+		c.duels[i] = &dup
+	}
+	for i, b := range s.brackets {
+		dup := *b
+		c.brackets[i] = &dup
+	}
+	for i, m := range s.matches {
+		dup := *m
+		c.matches[i] = &dup
+	}
+	return c
+}
+
+func (r *MemoryRepository) Open(dsn string) error {
+	return nil
+}
+
+func (r *MemoryRepository) Close() {
+	_db = nil
+}
+
+func (r *MemoryRepository) Migrate() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.migrated.IsZero() {
+		r.migrated = time.Now()
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Migrations() ([]MigrationState, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var recorded []*schemaMigration
+	if !r.migrated.IsZero() {
+		for _, m := range migrations {
+			recorded = append(recorded, &schemaMigration{
+				Version: m.version,
+				Name: m.name,
+				AppliedAt: r.migrated,
+			})
+		}
+	}
+	return migrationStates(recorded), nil
+}
+
+/* Transaction works on a copy of everything, which replaces the current
+   state only if tx succeeds. */
+func (r *MemoryRepository) Transaction(tx func (Tx) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	c := r.store.clone()
+	err := tx(c)
+	if err != nil {
+		return err
+	}
+	r.store = c
+	return nil
+}
+
+func (r *MemoryRepository) AddArtwork(a *Artwork) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.AddArtwork(a)
+}
+
+func (r *MemoryRepository) RemoveArtwork(a *Artwork) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stored, ok := r.store.artworks[a.ID]
+	if !ok || stored.DeletedAt.Valid {
+		return nil
+	}
+	stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	a.DeletedAt = stored.DeletedAt
+	return nil
+}
+
+func (r *MemoryRepository) RestoreArtwork(a *Artwork) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stored, ok := r.store.artworks[a.ID]
+	if ok {
+		stored.DeletedAt = gorm.DeletedAt{}
+	}
+	a.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (r *MemoryRepository) GetArtworkById(id int64) (*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	a, ok := r.store.artworks[uint(id)]
+	if !ok || a.DeletedAt.Valid {
+		return nil, gorm.ErrRecordNotFound
+	}
+	dup := *a
+	return &dup, nil
+}
+
+func (r *MemoryRepository) GetArtworkByFilename(filename string) (*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, a := range r.store.sorted(false) {
+		if a.Filename == filename {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *MemoryRepository) GetRemovedArtworkByFilename(filename string) (*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, a := range r.store.sorted(true) {
+		if a.Filename == filename && a.DeletedAt.Valid {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *MemoryRepository) GetAllArtworks() ([]*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.sorted(false), nil
+}
+
+func (r *MemoryRepository) GetArtworksWithLowestDuelCount(f Filter) ([]*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var lowest []*Artwork
+	for _, a := range matching(r.store.sorted(false), f) {
+		if len(lowest) > 0 && a.DuelCount > lowest[0].DuelCount {
+			continue
+		}
+		if len(lowest) > 0 && a.DuelCount < lowest[0].DuelCount {
+			lowest = nil
+		}
+		lowest = append(lowest, a)
+	}
+	return lowest, nil
+}
+
+func (r *MemoryRepository) GetLeaderboard(maxcount int, category string) ([]*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	lb := matching(r.store.sorted(false), Filter{Category: category})
+	sort.SliceStable(lb, func(i, j int) bool {
+		return lb[i].EloRating > lb[j].EloRating
+	})
+	if len(lb) > maxcount {
+		lb = lb[:maxcount]
+	}
+	return lb, nil
+}
+
+/* GetArtworksWithSimilarEloRating follows the two step query of the SQL
+   backends exactly, see there. */
+func (r *MemoryRepository) GetArtworksWithSimilarEloRating(benchmark *Artwork, count int, f Filter) ([]*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var higher, lower []*Artwork
+	for _, a := range matching(r.store.sorted(false), f) {
+		if a.EloRating > benchmark.EloRating {
+			higher = append(higher, a)
+		} else if a.ID != benchmark.ID {
+			lower = append(lower, a)
+		}
+	}
+	sort.SliceStable(higher, func(i, j int) bool {
+		return higher[i].EloRating < higher[j].EloRating
+	})
+	sort.SliceStable(lower, func(i, j int) bool {
+		return lower[i].EloRating > lower[j].EloRating
+	})
+	if len(higher) > count {
+		higher = higher[:count]
+	}
+	var remaining_count int
+	if len(higher) < (count / 2) {
+		remaining_count = count - len(higher)
+	} else {
+		remaining_count = (count / 2)
+	}
+	if len(lower) > remaining_count {
+		lower = lower[:remaining_count]
+	}
+	res := higher
+	for _, a := range lower {
+		res = append([]*Artwork{a}, res...)
+	}
+	if len(res) < count {
+		count = len(res)
+	}
+	return res[:count], nil
+}
+
+func (r *MemoryRepository) UpdateArtwork(a *Artwork) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.UpdateArtwork(a)
+}
+
+func (r *MemoryRepository) UpdateRatings(a *Artwork) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.UpdateRatings(a)
+}
+
+func (r *MemoryRepository) UpdateArtworkDetails(a *Artwork) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stored, ok := r.store.artworks[a.ID]
+	if !ok || stored.DeletedAt.Valid {
+		return nil
+	}
+	stored.Title = a.Title
+	stored.Artist = a.Artist
+	stored.Panel = a.Panel
+	stored.Category = a.Category
+	stored.Thumbnail = a.Thumbnail
+	stored.ScreenImage = a.ScreenImage
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *MemoryRepository) GetArtworkRank(a *Artwork) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.GetArtworkRank(a)
+}
+
+func (r *MemoryRepository) GetTotalDuelCount() (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	/* like the SQL query, this includes removed artworks */
+	var count int64
+	for _, a := range r.store.artworks {
+		count = count + int64(a.DuelCount)
+	}
+	return count / 2, nil
+}
+
+func (r *MemoryRepository) GetAllArtworksWithRemoved() ([]*Artwork, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.GetAllArtworksWithRemoved()
+}
+
+func (r *MemoryRepository) GetAllDuels() ([]*Duel, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.GetAllDuels()
+}
+
+func (r *MemoryRepository) UpdateDuel(d *Duel) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.UpdateDuel(d)
+}
+
+func (r *MemoryRepository) GetDuelsOfArtwork(id uint) ([]*Duel, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	all, _ := r.store.GetAllDuels()
+	var res []*Duel
+	for _, d := range all {
+		if d.Duelist1 == id || d.Duelist2 == id {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+func (r *MemoryRepository) AddDuel(d *Duel) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.AddDuel(d)
+}
+
+func (r *MemoryRepository) AddBracket(b *Bracket, matches []*Match) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	b.ID = r.store.nextBracket
+	b.CreatedAt = now
+	b.UpdatedAt = now
+	r.store.nextBracket = r.store.nextBracket + 1
+	dup := *b
+	r.store.brackets = append(r.store.brackets, &dup)
+	for _, m := range matches {
+		m.BracketID = b.ID
+		m.ID = r.store.nextMatch
+		m.CreatedAt = now
+		m.UpdatedAt = now
+		r.store.nextMatch = r.store.nextMatch + 1
+		dup := *m
+		r.store.matches = append(r.store.matches, &dup)
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetCurrentBracket() (*Bracket, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i := len(r.store.brackets) - 1; i >= 0; i-- {
+		if !r.store.brackets[i].DeletedAt.Valid {
+			dup := *r.store.brackets[i]
+			return &dup, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *MemoryRepository) RemoveBracket(b *Bracket) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, stored := range r.store.brackets {
+		if stored.ID == b.ID && !stored.DeletedAt.Valid {
+			stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+			b.DeletedAt = stored.DeletedAt
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpdateBracket(b *Bracket) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.UpdateBracket(b)
+}
+
+func (r *MemoryRepository) GetMatches(bracketId uint) ([]*Match, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.GetMatches(bracketId)
+}
+
+func (r *MemoryRepository) UpdateMatch(m *Match) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.store.UpdateMatch(m)
+}
+
+/* matching is the in-memory counterpart of filtered */
+func matching(all []*Artwork, f Filter) []*Artwork {
+	skip := map[uint]bool{}
+	for _, id := range f.Exclude {
+		skip[id] = true
+	}
+	var res []*Artwork
+	for _, a := range all {
+		if skip[a.ID] || (f.Category != "" && a.Category != f.Category) {
+			continue
+		}
+		if f.DuelsBelow == 0 || a.DuelCount < f.DuelsBelow {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+/* sorted returns copies of the artworks ordered by id */
+func (s *memoryStore) sorted(withRemoved bool) []*Artwork {
+	var res []*Artwork
+	for _, a := range s.artworks {
+		if a.DeletedAt.Valid && !withRemoved {
+			continue
+		}
+		dup := *a
+		res = append(res, &dup)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+/* the memoryStore methods below implement Tx */
+
+func (s *memoryStore) AddArtwork(a *Artwork) error {
+	now := time.Now()
+	a.ID = s.nextArtwork
+	a.CreatedAt = now
+	a.UpdatedAt = now
+	s.nextArtwork = s.nextArtwork + 1
+	dup := *a
+	s.artworks[a.ID] = &dup
+	return nil
+}
+
+func (s *memoryStore) UpdateArtwork(a *Artwork) error {
+	if a.ID == 0 {
+		return s.AddArtwork(a)
+	}
+	a.UpdatedAt = time.Now()
+	dup := *a
+	s.artworks[a.ID] = &dup
+	if a.ID >= s.nextArtwork {
+		s.nextArtwork = a.ID + 1
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateRatings(a *Artwork) error {
+	stored, ok := s.artworks[a.ID]
+	if !ok {
+		return nil
+	}
+	stored.EloRating = a.EloRating
+	stored.RatingDeviation = a.RatingDeviation
+	stored.RatingVolatility = a.RatingVolatility
+	stored.DuelCount = a.DuelCount
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *memoryStore) GetArtworkRank(a *Artwork) (int64, error) {
+	var count int64
+	for _, o := range s.artworks {
+		if !o.DeletedAt.Valid && o.EloRating > a.EloRating && o.Category == a.Category {
+			count = count + 1
+		}
+	}
+	return count + 1, nil
+}
+
+func (s *memoryStore) GetAllArtworksWithRemoved() ([]*Artwork, error) {
+	return s.sorted(true), nil
+}
+
+func (s *memoryStore) GetAllDuels() ([]*Duel, error) {
+	res := make([]*Duel, len(s.duels))
+	for i, d := range s.duels {
+		dup := *d
+		res[i] = &dup
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].When.Equal(res[j].When) {
+			return res[i].ID < res[j].ID
+		}
+		return res[i].When.Before(res[j].When)
+	})
+	return res, nil
+}
+
+func (s *memoryStore) UpdateDuel(d *Duel) error {
+	if d.ID == 0 {
+		return s.AddDuel(d)
+	}
+	d.UpdatedAt = time.Now()
+	dup := *d
+	for i, o := range s.duels {
+		if o.ID == d.ID {
+			s.duels[i] = &dup
+			return nil
+		}
+	}
+	s.duels = append(s.duels, &dup)
+	if d.ID >= s.nextDuel {
+		s.nextDuel = d.ID + 1
+	}
+	return nil
+}
+
+func (s *memoryStore) AddDuel(d *Duel) error {
+	now := time.Now()
+	d.ID = s.nextDuel
+	d.CreatedAt = now
+	d.UpdatedAt = now
+	s.nextDuel = s.nextDuel + 1
+	dup := *d
+	s.duels = append(s.duels, &dup)
+	return nil
+}
+
+func (s *memoryStore) UpdateBracket(b *Bracket) error {
+	b.UpdatedAt = time.Now()
+	dup := *b
+	for i, o := range s.brackets {
+		if o.ID == b.ID {
+			s.brackets[i] = &dup
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetMatches(bracketId uint) ([]*Match, error) {
+	var res []*Match
+	for _, m := range s.matches {
+		if m.BracketID == bracketId {
+			dup := *m
+			res = append(res, &dup)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Number < res[j].Number
+	})
+	return res, nil
+}
+
+func (s *memoryStore) UpdateMatch(m *Match) error {
+	m.UpdatedAt = time.Now()
+	dup := *m
+	for i, o := range s.matches {
+		if o.ID == m.ID {
+			s.matches[i] = &dup
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
@@ -0,0 +1,316 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/* MigrationState tells whether a step of the database schema has been
+   applied. */
+type MigrationState struct {
+	Version		int
+	/* "" for a step recorded in the database that this program doesn't
+	   know, i.e. the database is newer */
+	Name		string
+	Applied		bool
+	AppliedAt	time.Time
+}
+
+var ErrSchemaTooNew = errors.New("the database schema is newer than this program")
+
+/* migration is a numbered step of the database schema. Once released, a
+   step must never change, later changes go into a new one. Steps use
+   their own copies of the models, so they stay as they were when the
+   step was written. */
+type migration struct {
+	version		int
+	name		string
+	up		func(tx *gorm.DB) error
+}
+
+var migrations = []migration{
+	/* also takes over databases set up before steps were counted. Their
+	   ratings are still whole numbers, step 2 converts them. */
+	{1, "artworks, duels and finals brackets", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&artworkV1{}, &duelV1{}, &bracketV1{}, &matchV1{})
+	}},
+	{2, "ratings as floats", func(tx *gorm.DB) error {
+		err := alterColumns(tx, &artworkV2{}, "EloRating")
+		if err != nil {
+			return err
+		}
+		return alterColumns(tx, &duelV2{}, "Rating1Before", "Rating1After", "Rating2Before", "Rating2After")
+	}},
+	{3, "duel presentation and timeouts", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&duelV3{})
+	}},
+}
+
+/* alterColumns changes the type of columns to the one in the model.
+   SQLite copies the whole table for that and loses its indexes on the
+   way, so they are created again. */
+func alterColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	m := tx.Migrator()
+	for _, f := range fields {
+		err := m.AlterColumn(model, f)
+		if err != nil {
+			return err
+		}
+	}
+	stmt := &gorm.Statement{DB: tx}
+	err := stmt.Parse(model)
+	if err != nil {
+		return err
+	}
+	for name := range stmt.Schema.ParseIndexes() {
+		if !m.HasIndex(model, name) {
+			err = m.CreateIndex(model, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* SchemaVersion is the number of the last schema step this program
+   knows. */
+func SchemaVersion() int {
+	return migrations[len(migrations) - 1].version
+}
+
+/* CurrentVersion returns the number of the last step applied, 0 if
+   there is none. */
+func CurrentVersion(states []MigrationState) int {
+	version := 0
+	for _, s := range states {
+		if s.Applied {
+			version = max(version, s.Version)
+		}
+	}
+	return version
+}
+
+/* schemaMigration records an applied step */
+type schemaMigration struct {
+	Version		int		`gorm:"primaryKey; autoIncrement:false"`
+	Name		string		`gorm:"type:varchar(120); NOT NULL"`
+	AppliedAt	time.Time	`gorm:"NOT NULL"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+/* Migrate applies all steps not applied yet, in order, each one in its
+   own transaction. MySQL commits schema changes right away, so a failed
+   step may have to be cleaned up by hand there. */
+func (r *gormRepository) Migrate() error {
+	err := r.db.AutoMigrate(&schemaMigration{})
+	if err != nil {
+		return err
+	}
+	states, err := r.Migrations()
+	if err != nil {
+		return err
+	}
+	if CurrentVersion(states) > SchemaVersion() {
+		return ErrSchemaTooNew
+	}
+	applied := map[int]bool{}
+	for _, s := range states {
+		applied[s.Version] = s.Applied
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		err = r.db.Transaction(func(tx *gorm.DB) error {
+			err := m.up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version: m.version,
+				Name: m.name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("schema step %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+/* Migrations lists all steps this program knows and all steps recorded
+   in the database, ordered by version. It changes nothing. */
+func (r *gormRepository) Migrations() ([]MigrationState, error) {
+	var recorded []*schemaMigration
+	if r.db.Migrator().HasTable(&schemaMigration{}) {
+		err := r.db.Order("version asc").Find(&recorded).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return migrationStates(recorded), nil
+}
+
+func migrationStates(recorded []*schemaMigration) []MigrationState {
+	var res []MigrationState
+	i := 0
+	for _, m := range migrations {
+		for i < len(recorded) && recorded[i].Version < m.version {
+			res = append(res, MigrationState{
+				Version: recorded[i].Version,
+				Applied: true,
+				AppliedAt: recorded[i].AppliedAt,
+			})
+			i = i + 1
+		}
+		s := MigrationState{Version: m.version, Name: m.name}
+		if i < len(recorded) && recorded[i].Version == m.version {
+			s.Applied = true
+			s.AppliedAt = recorded[i].AppliedAt
+			i = i + 1
+		}
+		res = append(res, s)
+	}
+	for _, rec := range recorded[i:] {
+		res = append(res, MigrationState{
+			Version: rec.Version,
+			Applied: true,
+			AppliedAt: rec.AppliedAt,
+		})
+	}
+	return res
+}
+
+/* the models as of schema step 1, with ratings as whole numbers */
+
+type artworkV1 struct {
+	gorm.Model
+	Title		string	`gorm:"type:varchar(120); NOT NULL"`
+	Artist		string	`gorm:"type:varchar(120); NOT NULL"`
+	Panel		string	`gorm:"type:varchar(10); NOT NULL"`
+	Category	string	`gorm:"type:varchar(40); NOT NULL; default:''; index:idx_category"`
+	Filename	string	`gorm:"type:varchar(120); NOT NULL"`
+	Thumbnail	string	`gorm:"type:varchar(120); NOT NULL"`
+	ScreenImage	string	`gorm:"type:varchar(120); NOT NULL; default:''"`
+	DuelCount	uint64	`gorm:"index:idx_duel_count"`
+	EloRating	int16	`gorm:"index:idx_elo_rating"`
+	RatingDeviation		float64	`gorm:"NOT NULL; default:350"`
+	RatingVolatility	float64	`gorm:"NOT NULL; default:0.06"`
+}
+
+func (artworkV1) TableName() string {
+	return "artworks"
+}
+
+type duelV1 struct {
+	gorm.Model
+	Duelist1	uint		`gorm:"type:bigint; NOT NULL"`
+	Duelist2	uint		`gorm:"type:bigint; NOT NULL"`
+	Winner		uint		`gorm:"type:bigint; NOT NULL"`
+	When		time.Time	`gorm:"NOT NULL"`
+	Rating1Before	int16		`gorm:"NOT NULL; default:0"`
+	Rating1After	int16		`gorm:"NOT NULL; default:0"`
+	Rating2Before	int16		`gorm:"NOT NULL; default:0"`
+	Rating2After	int16		`gorm:"NOT NULL; default:0"`
+	Rank1Before	int64		`gorm:"NOT NULL; default:0"`
+	Rank1After	int64		`gorm:"NOT NULL; default:0"`
+	Rank2Before	int64		`gorm:"NOT NULL; default:0"`
+	Rank2After	int64		`gorm:"NOT NULL; default:0"`
+}
+
+func (duelV1) TableName() string {
+	return "duels"
+}
+
+type bracketV1 struct {
+	gorm.Model
+	Format		string		`gorm:"type:varchar(10); NOT NULL"`
+	BestOf		int		`gorm:"NOT NULL"`
+	Category	string		`gorm:"type:varchar(40); NOT NULL; default:''"`
+	Seeds		string		`gorm:"type:text; NOT NULL"`
+	Champion	uint		`gorm:"NOT NULL; default:0"`
+}
+
+func (bracketV1) TableName() string {
+	return "brackets"
+}
+
+type matchV1 struct {
+	gorm.Model
+	BracketID	uint		`gorm:"NOT NULL; index:idx_bracket"`
+	Number		int		`gorm:"NOT NULL"`
+	Round		string		`gorm:"type:varchar(4); NOT NULL"`
+	Artwork1	uint		`gorm:"NOT NULL; default:0"`
+	Artwork2	uint		`gorm:"NOT NULL; default:0"`
+	Wins1		int		`gorm:"NOT NULL; default:0"`
+	Wins2		int		`gorm:"NOT NULL; default:0"`
+	Winner		uint		`gorm:"NOT NULL; default:0"`
+	WinnerTo	int		`gorm:"NOT NULL"`
+	WinnerSlot	int		`gorm:"NOT NULL"`
+	LoserTo		int		`gorm:"NOT NULL"`
+	LoserSlot	int		`gorm:"NOT NULL"`
+}
+
+func (matchV1) TableName() string {
+	return "matches"
+}
+
+/* the models as of schema step 2 */
+
+type artworkV2 struct {
+	gorm.Model
+	Title		string	`gorm:"type:varchar(120); NOT NULL"`
+	Artist		string	`gorm:"type:varchar(120); NOT NULL"`
+	Panel		string	`gorm:"type:varchar(10); NOT NULL"`
+	Category	string	`gorm:"type:varchar(40); NOT NULL; default:''; index:idx_category"`
+	Filename	string	`gorm:"type:varchar(120); NOT NULL"`
+	Thumbnail	string	`gorm:"type:varchar(120); NOT NULL"`
+	ScreenImage	string	`gorm:"type:varchar(120); NOT NULL; default:''"`
+	DuelCount	uint64	`gorm:"index:idx_duel_count"`
+	EloRating	float64	`gorm:"index:idx_elo_rating"`
+	RatingDeviation		float64	`gorm:"NOT NULL; default:350"`
+	RatingVolatility	float64	`gorm:"NOT NULL; default:0.06"`
+}
+
+func (artworkV2) TableName() string {
+	return "artworks"
+}
+
+type duelV2 struct {
+	gorm.Model
+	Duelist1	uint		`gorm:"type:bigint; NOT NULL"`
+	Duelist2	uint		`gorm:"type:bigint; NOT NULL"`
+	Winner		uint		`gorm:"type:bigint; NOT NULL"`
+	When		time.Time	`gorm:"NOT NULL"`
+	Rating1Before	float64		`gorm:"NOT NULL; default:0"`
+	Rating1After	float64		`gorm:"NOT NULL; default:0"`
+	Rating2Before	float64		`gorm:"NOT NULL; default:0"`
+	Rating2After	float64		`gorm:"NOT NULL; default:0"`
+	Rank1Before	int64		`gorm:"NOT NULL; default:0"`
+	Rank1After	int64		`gorm:"NOT NULL; default:0"`
+	Rank2Before	int64		`gorm:"NOT NULL; default:0"`
+	Rank2After	int64		`gorm:"NOT NULL; default:0"`
+}
+
+func (duelV2) TableName() string {
+	return "duels"
+}
+
+/* the models as of schema step 3 */
+
+type duelV3 struct {
+	duelV2
+	ShownAt		*time.Time
+	DecisionMs	int64		`gorm:"NOT NULL; default:0"`
+	Source		string		`gorm:"type:varchar(20); NOT NULL; default:''"`
+	Kiosk		string		`gorm:"type:varchar(40); NOT NULL; default:''"`
+	TimedOut	bool		`gorm:"NOT NULL; default:false"`
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/driver/mysql"
+)
+
+type MysqlRepository struct {
+	gormRepository
+}
+
+func (r *MysqlRepository) Open(dsn string) error {
+	gormConfig := &gorm.Config{}
+	db, err := gorm.Open(mysql.Open(dsn), gormConfig)
+	if err != nil {
+		return err
+	}
+	r.db = db
+	return nil
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+/* SqliteRepository keeps everything in a single local file, for events
+   without a MySQL server. */
+type SqliteRepository struct {
+	gormRepository
+}
+
+func (r *SqliteRepository) Open(dsn string) error {
+	gormConfig := &gorm.Config{}
+	db, err := gorm.Open(sqlite.Open(dsn), gormConfig)
+	if err != nil {
+		return err
+	}
+	/* SQLite only has one writer at a time anyway, and a single
+	   connection avoids "database is locked" errors between the
+	   state machine and the image scanner */
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	r.db = db
+	return nil
+}
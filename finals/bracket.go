@@ -0,0 +1,208 @@
+/* Package finals lays out and advances the finals tournament: a seeded
+   single or double elimination bracket in which every match is a best-of
+   series of votes. */
+package finals
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+const (
+	Single = "single"
+	Double = "double"
+)
+
+/* the rounds of the grand final and of its replay */
+const (
+	grandFinal = "GF"
+	bracketReset = "GF2"
+)
+
+/* Build lays out all matches of a bracket for the given artwork ids,
+   best seed first. Their number must be a power of two, at least 4 for
+   double elimination. The best seeds meet as late as possible. In double
+   elimination the losers of the winners bracket get a second chance in
+   the losers bracket, and the winners of both meet in the grand final.
+   If the one from the losers bracket wins, both have lost once, and the
+   grand final is played again. */
+func Build(format string, seeds []uint) ([]*database.Match, error) {
+	n := len(seeds)
+	if n < 2 || n & (n - 1) != 0 {
+		return nil, fmt.Errorf("the finals need a power of two artworks, not %d", n)
+	}
+	if format == Double && n < 4 {
+		return nil, fmt.Errorf("double elimination needs at least 4 artworks")
+	}
+
+	var matches []*database.Match
+	add := func(round string, count int) []*database.Match {
+		var res []*database.Match
+		for i := 0; i < count; i++ {
+			m := &database.Match{
+				Number: len(matches),
+				Round: round,
+				WinnerTo: -1,
+				LoserTo: -1,
+			}
+			matches = append(matches, m)
+			res = append(res, m)
+		}
+		return res
+	}
+	winner := func(from *database.Match, to *database.Match, slot int) {
+		from.WinnerTo, from.WinnerSlot = to.Number, slot
+	}
+	loser := func(from *database.Match, to *database.Match, slot int) {
+		from.LoserTo, from.LoserSlot = to.Number, slot
+	}
+
+	order := seedOrder(n)
+	var upper, lower []*database.Match
+	for r := 1; n >> r > 0; r++ {
+		round := add(fmt.Sprintf("W%d", r), n >> r)
+		for i, m := range round {
+			if r == 1 {
+				m.Artwork1 = seeds[order[2 * i]]
+				m.Artwork2 = seeds[order[2 * i + 1]]
+			} else {
+				winner(upper[2 * i], m, 1)
+				winner(upper[2 * i + 1], m, 2)
+			}
+		}
+		if format == Double && r >= 2 {
+			/* the losers of the first round play each other, later
+			   ones meet the survivors of the losers bracket */
+			first := add(fmt.Sprintf("L%d", 2 * r - 3), n >> r)
+			for i, m := range first {
+				if r == 2 {
+					loser(upper[2 * i], m, 1)
+					loser(upper[2 * i + 1], m, 2)
+				} else {
+					winner(lower[2 * i], m, 1)
+					winner(lower[2 * i + 1], m, 2)
+				}
+			}
+			lower = add(fmt.Sprintf("L%d", 2 * r - 2), n >> r)
+			for i, m := range lower {
+				winner(first[i], m, 1)
+				/* crossed over, so they don't meet again right away */
+				loser(round[len(round) - 1 - i], m, 2)
+			}
+		}
+		upper = round
+	}
+	if format == Double {
+		final := add(grandFinal, 1)[0]
+		winner(upper[0], final, 1)
+		winner(lower[0], final, 2)
+		reset := add(bracketReset, 1)[0]
+		winner(final, reset, 2)
+		loser(final, reset, 1)
+	}
+	return matches, nil
+}
+
+/* seedOrder returns the seeds of the first round from top to bottom,
+   0 being the best: 1 meets n, and so on. */
+func seedOrder(n int) []int {
+	order := []int{0}
+	for len(order) < n {
+		var next []int
+		for _, s := range order {
+			next = append(next, s, 2 * len(order) - 1 - s)
+		}
+		order = next
+	}
+	return order
+}
+
+/* Next returns the match to play next, nil once the bracket is decided. */
+func Next(matches []*database.Match) *database.Match {
+	for _, m := range matches {
+		if m.Winner == 0 && m.Artwork1 != 0 && m.Artwork2 != 0 {
+			return m
+		}
+	}
+	return nil
+}
+
+/* Vote counts a vote for the artwork with id winner in match m. Once
+   either side has won the majority of bestOf votes, the winner and the
+   loser move on. Vote returns the matches that changed. */
+func Vote(matches []*database.Match, m *database.Match, winner uint, bestOf int) ([]*database.Match, error) {
+	if winner == m.Artwork1 {
+		m.Wins1 = m.Wins1 + 1
+	} else if winner == m.Artwork2 {
+		m.Wins2 = m.Wins2 + 1
+	} else {
+		return nil, fmt.Errorf("artwork %d is not in match %d", winner, m.Number)
+	}
+	changed := []*database.Match{m}
+	need := bestOf / 2 + 1
+	if m.Wins1 < need && m.Wins2 < need {
+		return changed, nil
+	}
+
+	w, l := m.Artwork1, m.Artwork2
+	if m.Wins2 >= need {
+		w, l = l, w
+	}
+	m.Winner = w
+	if m.Round == grandFinal && w == m.Artwork1 && m.WinnerTo >= 0 && m.WinnerTo < len(matches) {
+		/* the winners bracket finalist hasn't lost yet, no replay */
+		reset := matches[m.WinnerTo]
+		reset.Winner = w
+		return append(changed, reset), nil
+	}
+	move := func(id uint, to int, slot int) {
+		if to < 0 || to >= len(matches) {
+			return
+		}
+		next := matches[to]
+		if slot == 1 {
+			next.Artwork1 = id
+		} else {
+			next.Artwork2 = id
+		}
+		changed = append(changed, next)
+	}
+	move(w, m.WinnerTo, m.WinnerSlot)
+	move(l, m.LoserTo, m.LoserSlot)
+	return changed, nil
+}
+
+/* Champion returns the winner of the final, 0 while undecided. */
+func Champion(matches []*database.Match) uint {
+	for _, m := range matches {
+		/* only the winner of the final goes nowhere */
+		if m.WinnerTo < 0 && m.Winner != 0 {
+			return m.Winner
+		}
+	}
+	return 0
+}
+
+/* Seeds returns the artwork ids of a bracket by seed. */
+func Seeds(b *database.Bracket) []uint {
+	var res []uint
+	for _, s := range strings.Split(b.Seeds, ",") {
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err == nil {
+			res = append(res, uint(id))
+		}
+	}
+	return res
+}
+
+/* FormatSeeds is the counterpart to Seeds. */
+func FormatSeeds(ids []uint) string {
+	var s []string
+	for _, id := range ids {
+		s = append(s, strconv.FormatUint(uint64(id), 10))
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,239 @@
+package finals
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+/* the tests use the seed as artwork id, 1 being the best */
+func seeds(n int) []uint {
+	var res []uint
+	for i := 1; i <= n; i++ {
+		res = append(res, uint(i))
+	}
+	return res
+}
+
+func better(m *database.Match) uint {
+	return min(m.Artwork1, m.Artwork2)
+}
+
+/* play votes each match to its end, in the order Next gives, and returns
+   them as "round one-two winner" */
+func play(t *testing.T, matches []*database.Match, bestOf int, pick func(m *database.Match) uint) []string {
+	t.Helper()
+	var res []string
+	for range matches {
+		m := Next(matches)
+		if m == nil {
+			break
+		}
+		w := pick(m)
+		for m.Winner == 0 {
+			_, err := Vote(matches, m, w, bestOf)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		res = append(res, fmt.Sprintf("%s %d-%d %d", m.Round, m.Artwork1, m.Artwork2, m.Winner))
+	}
+	if Next(matches) != nil {
+		t.Fatalf("still matches to play after %s", strings.Join(res, ", "))
+	}
+	return res
+}
+
+func build(t *testing.T, format string, n int) []*database.Match {
+	t.Helper()
+	matches, err := Build(format, seeds(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range matches {
+		if m.Number != i {
+			t.Fatalf("match %d has number %d", i, m.Number)
+		}
+	}
+	return matches
+}
+
+func expect(t *testing.T, got []string, want []string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+}
+
+func TestSeedOrder(t *testing.T) {
+	for _, tc := range []struct {
+		n	int
+		want	[]int
+	}{
+		{2, []int{0, 1}},
+		{4, []int{0, 3, 1, 2}},
+		{8, []int{0, 7, 3, 4, 1, 6, 2, 5}},
+	} {
+		got := seedOrder(tc.n)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d seeds: got %v, want %v", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	for _, tc := range []struct {
+		format	string
+		n	int
+	}{
+		{Single, 0},
+		{Single, 1},
+		{Single, 3},
+		{Single, 6},
+		{Double, 2},
+		{Double, 12},
+	} {
+		_, err := Build(tc.format, seeds(tc.n))
+		if err == nil {
+			t.Errorf("%s with %d seeds: got no error", tc.format, tc.n)
+		}
+	}
+}
+
+func TestSingle4(t *testing.T) {
+	matches := build(t, Single, 4)
+	expect(t, play(t, matches, 3, better), []string{
+		"W1 1-4 1",
+		"W1 2-3 2",
+		"W2 1-2 1",
+	})
+	if c := Champion(matches); c != 1 {
+		t.Errorf("got champion %d, want 1", c)
+	}
+}
+
+func TestSingle8(t *testing.T) {
+	matches := build(t, Single, 8)
+	/* the underdogs win the first round */
+	upset := func(m *database.Match) uint {
+		if m.Round == "W1" {
+			return max(m.Artwork1, m.Artwork2)
+		}
+		return better(m)
+	}
+	expect(t, play(t, matches, 1, upset), []string{
+		"W1 1-8 8",
+		"W1 4-5 5",
+		"W1 2-7 7",
+		"W1 3-6 6",
+		"W2 8-5 5",
+		"W2 7-6 6",
+		"W3 5-6 5",
+	})
+	if c := Champion(matches); c != 5 {
+		t.Errorf("got champion %d, want 5", c)
+	}
+}
+
+func TestDouble4(t *testing.T) {
+	matches := build(t, Double, 4)
+	expect(t, play(t, matches, 3, better), []string{
+		"W1 1-4 1",
+		"W1 2-3 2",
+		"W2 1-2 1",
+		/* the losers of the first round */
+		"L1 4-3 3",
+		/* against the loser of the winners final */
+		"L2 3-2 2",
+		"GF 1-2 1",
+	})
+	if c := Champion(matches); c != 1 {
+		t.Errorf("got champion %d, want 1", c)
+	}
+}
+
+func TestDouble4Reset(t *testing.T) {
+	matches := build(t, Double, 4)
+	/* 2 comes back through the losers bracket and beats 1 twice */
+	comeback := func(m *database.Match) uint {
+		if m.Round == "GF" || m.Round == "GF2" {
+			return 2
+		}
+		return better(m)
+	}
+	expect(t, play(t, matches, 3, comeback), []string{
+		"W1 1-4 1",
+		"W1 2-3 2",
+		"W2 1-2 1",
+		"L1 4-3 3",
+		"L2 3-2 2",
+		"GF 1-2 2",
+		/* both have lost once, so it's played again */
+		"GF2 1-2 2",
+	})
+	if c := Champion(matches); c != 2 {
+		t.Errorf("got champion %d, want 2", c)
+	}
+}
+
+func TestDouble8(t *testing.T) {
+	matches := build(t, Double, 8)
+	expect(t, play(t, matches, 1, better), []string{
+		"W1 1-8 1",
+		"W1 4-5 4",
+		"W1 2-7 2",
+		"W1 3-6 3",
+		"W2 1-4 1",
+		"W2 2-3 2",
+		"L1 8-5 5",
+		"L1 7-6 6",
+		/* the losers of the winners bracket cross over to the other
+		   half, 3 from the bottom meets 5 from the top */
+		"L2 5-3 3",
+		"L2 6-4 4",
+		"W3 1-2 1",
+		"L3 3-4 3",
+		"L4 3-2 2",
+		"GF 1-2 1",
+	})
+	if c := Champion(matches); c != 1 {
+		t.Errorf("got champion %d, want 1", c)
+	}
+	reset := matches[len(matches) - 1]
+	if reset.Round != "GF2" || reset.Artwork1 != 0 || reset.Artwork2 != 0 || reset.Winner != 1 {
+		t.Errorf("got replay %+v, want it skipped", reset)
+	}
+}
+
+func TestVote(t *testing.T) {
+	matches := build(t, Single, 4)
+	m := matches[0]
+
+	_, err := Vote(matches, m, 2, 3)
+	if err == nil {
+		t.Error("vote for an artwork not in the match: got no error")
+	}
+	changed, err := Vote(matches, m, 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || m.Wins2 != 1 || m.Winner != 0 {
+		t.Errorf("after one vote of three: %d changed, %d wins, winner %d", len(changed), m.Wins2, m.Winner)
+	}
+	Vote(matches, m, 1, 3)
+	changed, err = Vote(matches, m, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* decided 2:1, the winner moves on to the final */
+	final := matches[2]
+	if m.Winner != 1 || len(changed) != 2 || changed[1] != final || final.Artwork1 != 1 {
+		t.Errorf("got winner %d, %d changed, final %d-%d", m.Winner, len(changed), final.Artwork1, final.Artwork2)
+	}
+	if Champion(matches) != 0 {
+		t.Error("got a champion before the final")
+	}
+}
@@ -0,0 +1,151 @@
+package imagescan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+type ExifData struct {
+	SourceFile	string	`json:"SourceFile"`
+	UserComment	string	`json:"UserComment"`
+}
+
+var errNoUserComment = errors.New("no exif UserComment")
+
+/* readUserComment returns the EXIF UserComment of an image. If it can't be
+   read natively and images.exiftool is configured, exiftool is tried. */
+func readUserComment(path string) (string, error) {
+	usercomment, err := readUserCommentNative(path)
+	if err == nil || errors.Is(err, errNoUserComment) {
+		return usercomment, err
+	}
+	exiftool := config.ImageExiftool()
+	if exiftool == "" {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "warn: can't read exif data of %s, trying exiftool: %s\n", path, err)
+	return readUserCommentExiftool(exiftool, path)
+}
+
+func readUserCommentNative(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	x, err := exif.Decode(f)
+	if err != nil {
+		if noExif(err) {
+			return "", errNoUserComment
+		}
+		if exif.IsCriticalError(err) {
+			return "", err
+		}
+		/* some tags were broken, but maybe not the one we need */
+	}
+	tag, err := x.Get(exif.UserComment)
+	if err != nil {
+		var tnf exif.TagNotPresentError
+		if errors.As(err, &tnf) {
+			return "", errNoUserComment
+		}
+		return "", err
+	}
+	return decodeUserComment(tag.Val, x.Tiff.Order)
+}
+
+/* noExif tells whether exif.Decode failed because the image has no EXIF
+   data at all: it ran out of JPEG looking for the APP1 segment, or that
+   segment holds something else, e.g. XMP. goexif has no error values for
+   these, so the message has to do. */
+func noExif(err error) bool {
+	return errors.Is(err, io.EOF) || err.Error() == "exif: failed to find exif intro marker"
+}
+
+/* decodeUserComment handles the 8 byte character code prefix that EXIF
+   puts in front of the UserComment, see the EXIF 2.3 spec, 4.6.5 */
+func decodeUserComment(val []byte, order binary.ByteOrder) (string, error) {
+	if len(val) < 8 {
+		return decodeText(val), nil
+	}
+	prefix, text := val[:8], val[8:]
+	switch {
+	case bytes.Equal(prefix, []byte("ASCII\x00\x00\x00")):
+		return decodeText(text), nil
+	case bytes.Equal(prefix, []byte("\x00\x00\x00\x00\x00\x00\x00\x00")):
+		/* undefined, usually whatever the camera or tool likes */
+		return decodeText(text), nil
+	case bytes.Equal(prefix, []byte("UNICODE\x00")):
+		return decodeUCS2(text, order), nil
+	case bytes.Equal(prefix, []byte("JIS\x00\x00\x00\x00\x00")):
+		return "", errors.New("JIS encoded UserComment is not supported")
+	}
+	/* no prefix at all, some tools write plain strings */
+	return decodeText(val), nil
+}
+
+/* decodeText reads UTF-8, falling back to Latin-1 */
+func decodeText(b []byte) string {
+	b = bytes.TrimRight(b, "\x00 ")
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteRune(rune(c))
+	}
+	return sb.String()
+}
+
+/* decodeUCS2 reads UTF-16 in the byte order of the EXIF data, unless
+   there is a byte order mark */
+func decodeUCS2(b []byte, order binary.ByteOrder) string {
+	if len(b) >= 2 {
+		if b[0] == 0xfe && b[1] == 0xff {
+			order, b = binary.BigEndian, b[2:]
+		} else if b[0] == 0xff && b[1] == 0xfe {
+			order, b = binary.LittleEndian, b[2:]
+		}
+	}
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i + 1 < len(b); i += 2 {
+		u = append(u, order.Uint16(b[i:]))
+	}
+	for len(u) > 0 && (u[len(u)-1] == 0 || u[len(u)-1] == ' ') {
+		u = u[:len(u)-1]
+	}
+	return string(utf16.Decode(u))
+}
+
+func readUserCommentExiftool(exiftool string, path string) (string, error) {
+	out, err := exec.Command(exiftool, "-charset", "UTF8", "-j", "-usercomment", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("error executing exiftool: %s", err)
+	}
+
+	var exif []ExifData
+	err = json.Unmarshal(out, &exif)
+	if err != nil {
+		return "", fmt.Errorf("error parsing exiftool output: %s", err)
+	}
+	if len(exif) != 1 {
+		return "", fmt.Errorf("unexpected exif array length: %d", len(exif))
+	}
+	if exif[0].UserComment == "" {
+		return "", errNoUserComment
+	}
+	return exif[0].UserComment, nil
+}
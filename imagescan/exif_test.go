@@ -0,0 +1,94 @@
+package imagescan
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+/* the testdata images carry this comment in different encodings, see
+   TestReadUserCommentNative */
+const testComment = "ef-artshow-tags-version: v1\nartist: Zoë Fuchs\ntitle: Füchse im Schnee\npanel: A12"
+
+func TestReadUserCommentNative(t *testing.T) {
+	for _, tc := range []struct {
+		file	string
+		want	string
+		err	error
+	}{
+		{"ascii.jpg", testComment, nil},
+		{"unicode-le.jpg", testComment, nil},
+		{"unicode-be.jpg", testComment, nil},
+		{"undefined.jpg", testComment, nil},
+		{"no-comment.jpg", "", errNoUserComment},
+		{"no-exif.jpg", "", errNoUserComment},
+		{"xmp-only.jpg", "", errNoUserComment},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			got, err := readUserCommentNative("testdata/" + tc.file)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeUserComment(t *testing.T) {
+	for _, tc := range []struct {
+		name	string
+		val	[]byte
+		order	binary.ByteOrder
+		want	string
+	}{
+		{"ascii", []byte("ASCII\x00\x00\x00Kim\x00"), binary.BigEndian, "Kim"},
+		{"undefined", []byte("\x00\x00\x00\x00\x00\x00\x00\x00Kim  "), binary.BigEndian, "Kim"},
+		{"unicode big endian", []byte("UNICODE\x00\x00K\x00i\x00m"), binary.BigEndian, "Kim"},
+		{"unicode little endian", []byte("UNICODE\x00K\x00i\x00m\x00"), binary.LittleEndian, "Kim"},
+		{"no prefix", []byte("Kim"), binary.BigEndian, "Kim"},
+		{"no prefix, long", []byte("Kim Fuchs"), binary.BigEndian, "Kim Fuchs"},
+		{"latin-1", []byte("ASCII\x00\x00\x00Zo\xeb"), binary.BigEndian, "Zoë"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeUserComment(tc.val, tc.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	_, err := decodeUserComment([]byte("JIS\x00\x00\x00\x00\x00Kim"), binary.BigEndian)
+	if err == nil {
+		t.Error("JIS: got no error")
+	}
+}
+
+func TestDecodeUCS2(t *testing.T) {
+	for _, tc := range []struct {
+		name	string
+		b	[]byte
+		order	binary.ByteOrder
+		want	string
+	}{
+		{"big endian", []byte("\x00Z\x00o\x00\xeb"), binary.BigEndian, "Zoë"},
+		{"little endian", []byte("Z\x00o\x00\xeb\x00"), binary.LittleEndian, "Zoë"},
+		/* a byte order mark beats the order of the EXIF data */
+		{"big endian mark", []byte("\xfe\xff\x00Z\x00o"), binary.LittleEndian, "Zo"},
+		{"little endian mark", []byte("\xff\xfeZ\x00o\x00"), binary.BigEndian, "Zo"},
+		{"padding", []byte("\x00Z\x00o\x00 \x00\x00"), binary.BigEndian, "Zo"},
+		{"odd length", []byte("\x00Z\x00"), binary.BigEndian, "Z"},
+		{"surrogate pair", []byte("\xd8\x3d\xde\x00"), binary.BigEndian, "\U0001f600"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := decodeUCS2(tc.b, tc.order)
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -3,10 +3,8 @@ package imagescan
 import (
 	"path/filepath"
 	"fmt"
-	"encoding/json"
 	"errors"
 	"os"
-	"os/exec"
 	"regexp"
 	"strings"
 
@@ -14,26 +12,54 @@ import (
 	"github.com/tinx/proto-artbattle/internal/repository/config"
 )
 
-type ExifData struct {
-	SourceFile	string	`json:"SourceFile"`
-	UserComment	string	`json:"UserComment"`
-}
-
 func Scan(root string) error {
 	err := filepath.Walk(root, ScanEntry)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "file walk error: %s\n", err)
 		return err
 	}
+	return RetireMissing(root)
+}
+
+/* RetireMissing removes artworks whose image file is gone, so they are
+   no longer paired. Their ratings are kept in case the file comes back. */
+func RetireMissing(root string) error {
+	db, err := database.GetDB();
+	all, err := db.GetAllArtworks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading artworks: %s\n", err)
+		return err
+	}
+	for _, a := range all {
+		_, err := os.Stat(root + a.Filename)
+		if errors.Is(err, os.ErrNotExist) {
+			retireArtwork(a)
+		}
+	}
 	return nil
 }
 
+func retireArtwork(a *database.Artwork) {
+	db, err := database.GetDB();
+	err = db.RemoveArtwork(a)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error removing db record for file '%s'. %s\n", a.Filename, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "artwork '%s' removed, file is gone: %s\n", a.Title, a.Filename)
+}
+
 func ScanEntry(path string, info os.FileInfo, err error) error {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "file walk error: %s\n", err)
 		return err
 	}
 
+	if info.IsDir() && filepath.Clean(path) == filepath.Clean(config.ImagePath() + config.ImageCacheDir()) {
+		/* our own generated images */
+		return filepath.SkipDir
+	}
+
 	if !info.Mode().IsRegular() {
 		return nil
 	}
@@ -47,26 +73,13 @@ func ScanEntry(path string, info os.FileInfo, err error) error {
 		return nil
 	}
 
-	out, err := exec.Command("/usr/bin/exiftool", "-charset", "UTF8", "-j", "-usercomment", path).Output()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error executing exiftool for %s: %s\n", path, err)
+	usercomment, err := readUserComment(path)
+	if err != nil && !errors.Is(err, errNoUserComment) {
+		fmt.Fprintf(os.Stderr, "error reading exif UserComment for %s: %s\n", path, err)
 		return nil // not err -> continue with next file
 	}
-
-	var exif []ExifData
-	err = json.Unmarshal(out, &exif)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error parsing exiftool output for %s: %s\n", path, err)
-		return nil // not err -> continue with next file
-	}
-	if len(exif) != 1 {
-		fmt.Fprintf(os.Stderr, "unexpected exif array length for %s: %d\n", path, len(exif))
-		return nil // not err -> continue with next file
-	}
-
-	usercomment := exif[0].UserComment
 	if usercomment == "" {
-		fmt.Fprintf(os.Stderr, "missing exif UserComment for %s: %s\n", path)
+		fmt.Fprintf(os.Stderr, "missing exif UserComment for %s\n", path)
 		return nil // not err -> continue with next file
 	}
 
@@ -76,8 +89,14 @@ func ScanEntry(path string, info os.FileInfo, err error) error {
 		return nil // not err -> continue with next file
 	}
 
-	artist, title, panel, err := parseExifUserComment(path, usercomment)
-	updateArtworkRecord(path, artist, title, panel, thumbnail)
+	if thumbnail == "" {
+		thumbnail = generateThumbnail(path)
+	}
+	screen := generateScreenImage(path)
+
+	artist, title, panel, tag, err := parseExifUserComment(path, usercomment)
+	category := categoryOf(path, tag, panel)
+	updateArtworkRecord(path, artist, title, panel, category, thumbnail, screen)
 	return nil
 }
 
@@ -97,44 +116,103 @@ func checkForThumbnail(path string) (string, error) {
 	return s, nil
 }
 
-func parseExifUserComment(path string, usercomment string) (string, string, string, error) {
+func generateThumbnail(path string) string {
+	w, h := config.ImageThumbnailSize()
+	if w == 0 && h == 0 {
+		return ""
+	}
+	thumbnail, err := generateVariant(path, "_tn", w, h)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error generating thumbnail of file=%s, error: %s\n", path, err)
+		return ""
+	}
+	return thumbnail
+}
+
+func generateScreenImage(path string) string {
+	w, h := config.ImageScreenSize()
+	if w == 0 && h == 0 {
+		return ""
+	}
+	screen, err := generateVariant(path, "_screen", w, h)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error generating screen image of file=%s, error: %s\n", path, err)
+		return ""
+	}
+	return screen
+}
+
+/* parseExifUserComment returns artist, title, panel and the optional
+   category tag, which may follow the panel line. */
+func parseExifUserComment(path string, usercomment string) (string, string, string, string, error) {
 	lines := strings.Split(usercomment, "\n")
 
 	if len(lines) < 4 {
 		fmt.Fprintf(os.Stderr, "warn: short exif data, path=%s\n", path)
-		return "", "", "", errors.New("short exif data, path: " + path)
+		return "", "", "", "", errors.New("short exif data, path: " + path)
 	}
 
 	/* verifz format version */
 	tag_version, err := splitLine(path, lines[0], "ef-artshow-tags-version")
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", "", err
 	}
 	if tag_version != "v1" {
 		fmt.Fprintf(os.Stderr, "warn: unexpected tags version: %s, path=%s\n", tag_version, path)
-		return "", "", "", errors.New("unexpected tags version: " + tag_version + ", file=" + path)
+		return "", "", "", "", errors.New("unexpected tags version: " + tag_version + ", file=" + path)
 	}
 
 	artist, err := splitLine(path, lines[1], "artist")
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", "", err
 	}
 	title, err := splitLine(path, lines[2], "title")
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", "", err
 	}
 	panel, err := splitLine(path, lines[3], "panel")
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", "", err
 	}
 
-	return artist, title, panel, nil
+	var category string
+	for _, line := range lines[4:] {
+		key, value, found := strings.Cut(line, ":")
+		if found && strings.Trim(key, " \n") == "category" {
+			category = strings.Trim(value, " \n")
+		}
+	}
+
+	return artist, title, panel, category, nil
+}
+
+/* categoryOf returns the configured category named by the tag, ignoring
+   case, or else the one the panel number belongs to, or else the
+   fallback category. */
+func categoryOf(path string, tag string, panel string) string {
+	names := config.CategoryNames()
+	if len(names) == 0 {
+		return ""
+	}
+	if tag != "" {
+		for _, name := range names {
+			if strings.EqualFold(name, tag) {
+				return name
+			}
+		}
+		fmt.Fprintf(os.Stderr, "warn: unknown category '%s', path=%s\n", tag, path)
+	}
+	category := config.CategoryForPanel(panel)
+	if category == "" {
+		category = config.CategoryFallback()
+	}
+	return category
 }
 
 func splitLine(path string, line string, expected_key string) (value string, err error) {
 	key, value, found := strings.Cut(line, ":")
 	if !found {
-		fmt.Fprintf(os.Stderr, "warn: parse error, no colon found in exif line, path=%s, %s\n", path)
+		fmt.Fprintf(os.Stderr, "warn: parse error, no colon found in exif line, path=%s, %s\n", path, line)
 		return "", fmt.Errorf("splitLine: no colon found")
 	}
 	key = strings.Trim(key, " \n")
@@ -146,36 +224,64 @@ func splitLine(path string, line string, expected_key string) (value string, err
 	return value, nil
 }
 
-func updateArtworkRecord(path string, artist string, title string, panel string, thumbnail string) {
+func updateArtworkRecord(path string, artist string, title string, panel string, category string, thumbnail string, screen string) {
 	path = path[len(config.ImagePath()):]
 	if thumbnail != "" {
 		thumbnail = thumbnail[len(config.ImagePath()):]
 	}
+	if screen != "" {
+		screen = screen[len(config.ImagePath()):]
+	}
 	db, err := database.GetDB();
 	a, err := db.GetArtworkByFilename(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error fetching db record for file '%s'. %s\n", path, err)
 		return
 	}
+	if a == nil {
+		/* the file might be back after it went missing, in which
+		   case it keeps its rating */
+		a, err = db.GetRemovedArtworkByFilename(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error fetching db record for file '%s'. %s\n", path, err)
+			return
+		}
+		if a != nil {
+			err = db.RestoreArtwork(a)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error restoring db record for file '%s'. %s\n", path, err)
+				return
+			}
+		}
+	}
 	if a != nil {
 		// file is already know to our database -> do nothing
-		if a.Title == title && a.Artist == artist && a.Panel == panel && a.Thumbnail == thumbnail {
+		if a.Title == title && a.Artist == artist && a.Panel == panel && a.Category == category && a.Thumbnail == thumbnail && a.ScreenImage == screen {
 			return
 		}
 		a.Title = title
 		a.Artist = artist
 		a.Panel = panel
+		a.Category = category
 		a.Thumbnail = thumbnail
-		db.UpdateArtwork(a)
+		a.ScreenImage = screen
+		err = db.UpdateArtworkDetails(a)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error updating db record for file '%s'. %s\n", path, err)
+		}
 		return
 	}
 	a = &database.Artwork{
 		Title: title,
 		Artist: artist,
 		Panel: panel,
+		Category: category,
 		Filename: path,
 		Thumbnail: thumbnail,
-		EloRating: int16(config.RatingDefaultPoints()),
+		ScreenImage: screen,
+		EloRating: float64(config.RatingDefaultPoints()),
+		RatingDeviation: config.RatingGlicko2InitialDeviation(),
+		RatingVolatility: config.RatingGlicko2InitialVolatility(),
 		DuelCount: 0,
 	}
 	err = db.AddArtwork(a)
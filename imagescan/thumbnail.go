@@ -0,0 +1,163 @@
+package imagescan
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+	"golang.org/x/image/draw"
+)
+
+/* generateVariant writes a downscaled copy of an image into the cache
+   directory and returns its path. Existing copies are reused unless the
+   source image is newer. */
+func generateVariant(path string, suffix string, maxWidth int, maxHeight int) (string, error) {
+	root := config.ImagePath()
+	if !strings.HasPrefix(path, root) {
+		return "", errors.New("image is outside of the image directory: " + path)
+	}
+	rel := path[len(root):]
+	out := root + config.ImageCacheDir() + strings.TrimSuffix(rel, filepath.Ext(rel)) + suffix + ".jpg"
+
+	src, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	dst, err := os.Stat(out)
+	if err == nil && !dst.ModTime().Before(src.ModTime()) {
+		return out, nil
+	}
+
+	img, err := loadOriented(path)
+	if err != nil {
+		return "", err
+	}
+	img = downscale(img, maxWidth, maxHeight)
+
+	err = os.MkdirAll(filepath.Dir(out), 0755)
+	if err != nil {
+		return "", err
+	}
+	/* write to a temporary file first, so nobody sees half an image */
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".tmp-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: config.ImageJpegQuality()})
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	err = os.Rename(tmp.Name(), out)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return out, nil
+}
+
+/* loadOriented decodes an image and rotates it upright according to its
+   EXIF Orientation tag. Generated copies have no EXIF data, so browsers
+   couldn't do that for us. */
+func loadOriented(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	orientation := 1
+	_, err = f.Seek(0, 0)
+	if err == nil {
+		x, err := exif.Decode(f)
+		if x != nil && (err == nil || !exif.IsCriticalError(err)) {
+			tag, err := x.Get(exif.Orientation)
+			if err == nil {
+				o, err := tag.Int(0)
+				if err == nil {
+					orientation = o
+				}
+			}
+		}
+	}
+	return orient(img, orientation), nil
+}
+
+/* orient applies one of the eight EXIF orientations. Each maps a pixel of
+   the upright image at (x, y) to a pixel of the stored image. */
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	ow, oh := w, h
+	if orientation >= 5 {
+		ow, oh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, ow, oh))
+	for y := 0; y < oh; y++ {
+		for x := 0; x < ow; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w - 1 - x, y
+			case 3: // rotated 180
+				sx, sy = w - 1 - x, h - 1 - y
+			case 4: // mirrored vertically
+				sx, sy = x, h - 1 - y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90 clockwise
+				sx, sy = y, h - 1 - x
+			case 7: // transversed
+				sx, sy = w - 1 - y, h - 1 - x
+			case 8: // rotated 90 counter-clockwise
+				sx, sy = w - 1 - y, x
+			}
+			out.Set(x, y, img.At(b.Min.X + sx, b.Min.Y + sy))
+		}
+	}
+	return out
+}
+
+/* downscale fits an image into maxWidth x maxHeight, keeping the aspect
+   ratio. Images are never enlarged. */
+func downscale(img image.Image, maxWidth int, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+	scale := float64(maxWidth) / float64(w)
+	if s := float64(maxHeight) / float64(h); s < scale {
+		scale = s
+	}
+	nw := int(float64(w) * scale + 0.5)
+	nh := int(float64(h) * scale + 0.5)
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	out := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	draw.CatmullRom.Scale(out, out.Bounds(), img, b, draw.Src, nil)
+	return out
+}
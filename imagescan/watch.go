@@ -0,0 +1,207 @@
+package imagescan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* Files are only scanned once they haven't changed for this long, so we
+   don't pick up images that are still being copied. */
+const settleDelay = 2 * time.Second
+
+var thumbnailRe = regexp.MustCompile(`^(.*)_tn(\.(jpg|jpeg|JPG|JPEG))$`)
+
+type watcher struct {
+	root		string
+	fsw		*fsnotify.Watcher
+	lock		sync.Mutex
+	pending		map[string]*time.Timer
+	sizes		map[string]int64
+}
+
+/* Watch keeps the artworks in sync with the image directory: new and
+   changed images are scanned, and artworks whose file disappears are
+   retired. */
+func Watch(root string) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &watcher{
+		root: root,
+		fsw: fsw,
+		pending: map[string]*time.Timer{},
+		sizes: map[string]int64{},
+	}
+	err = w.addDirectories(root)
+	if err != nil {
+		fsw.Close()
+		return err
+	}
+	go w.run()
+	return nil
+}
+
+func (w *watcher) addDirectories(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filepath.Clean(path) == filepath.Clean(w.root + config.ImageCacheDir()) {
+				return filepath.SkipDir
+			}
+			return w.fsw.Add(path)
+		}
+		return nil
+	})
+}
+
+func (w *watcher) run() {
+	for {
+		select {
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.handle(ev)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "image watcher error: %s\n", err)
+		}
+	}
+}
+
+func (w *watcher) handle(ev fsnotify.Event) {
+	/* fsnotify cleans paths, but the database has them relative to
+	   the configured root as-is */
+	rel, err := filepath.Rel(filepath.Clean(w.root), ev.Name)
+	if err != nil {
+		return
+	}
+	ev.Name = w.root + rel
+
+	if strings.HasPrefix(ev.Name, w.root + config.ImageCacheDir()) {
+		/* our own generated images */
+		return
+	}
+
+	if ev.Has(fsnotify.Create) {
+		info, err := os.Stat(ev.Name)
+		if err == nil && info.IsDir() {
+			/* a whole directory was copied or moved in */
+			err = w.addDirectories(ev.Name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error watching directory %s: %s\n", ev.Name, err)
+			}
+			filepath.Walk(ev.Name, func(path string, info os.FileInfo, err error) error {
+				if err == nil && info.Mode().IsRegular() {
+					w.schedule(path)
+				}
+				return nil
+			})
+			return
+		}
+	}
+	match, _ := regexp.MatchString("^.*\\.(jpg|JPG|jpeg|JPEG)$", ev.Name)
+	if !match {
+		if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+			/* possibly a directory full of images went away */
+			w.scheduleRetireMissing()
+		}
+		return
+	}
+	w.schedule(ev.Name)
+}
+
+func (w *watcher) scheduleRetireMissing() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	t, ok := w.pending[w.root]
+	if ok {
+		t.Reset(settleDelay)
+		return
+	}
+	w.pending[w.root] = time.AfterFunc(settleDelay, func() {
+		w.lock.Lock()
+		delete(w.pending, w.root)
+		w.lock.Unlock()
+		RetireMissing(w.root)
+	})
+}
+
+/* schedule (re-)starts the settle timer for a file */
+func (w *watcher) schedule(path string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	t, ok := w.pending[path]
+	if ok {
+		t.Reset(settleDelay)
+		return
+	}
+	w.pending[path] = time.AfterFunc(settleDelay, func() { w.settled(path) })
+}
+
+func (w *watcher) settled(path string) {
+	info, err := os.Stat(path)
+
+	w.lock.Lock()
+	if err == nil {
+		/* still growing? wait some more */
+		last, seen := w.sizes[path]
+		w.sizes[path] = info.Size()
+		if !seen || last != info.Size() {
+			w.pending[path].Reset(settleDelay)
+			w.lock.Unlock()
+			return
+		}
+	}
+	delete(w.pending, path)
+	delete(w.sizes, path)
+	w.lock.Unlock()
+
+	/* a thumbnail changed -> rescan the image it belongs to */
+	m := thumbnailRe.FindStringSubmatch(path)
+	if m != nil {
+		path = m[1] + m[2]
+		info, err = os.Stat(path)
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		w.retire(path)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error checking file %s: %s\n", path, err)
+		return
+	}
+	ScanEntry(path, info, nil)
+}
+
+func (w *watcher) retire(path string) {
+	if len(path) < len(config.ImagePath()) {
+		return
+	}
+	db, err := database.GetDB();
+	a, err := db.GetArtworkByFilename(path[len(config.ImagePath()):])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error fetching db record for file '%s'. %s\n", path, err)
+		return
+	}
+	if a == nil {
+		return
+	}
+	retireArtwork(a)
+}
@@ -0,0 +1,30 @@
+package input
+
+import (
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* FromConfiguration sets up a Mux with all sources listed in
+   input.sources. Call Start on the result to begin reading. */
+func FromConfiguration() *Mux {
+	m := NewMux()
+	for _, name := range config.InputSources() {
+		switch name {
+		case "serial":
+			settings := SerialSettings{
+				BaudRate: config.SerialPortBaudRate(),
+				Parity: config.SerialPortParity(),
+				StopBits: config.SerialPortStopBits(),
+				Raw: config.SerialPortRawMode(),
+			}
+			m.Add(NewSerialSource(config.SerialPortDeviceFile(), settings))
+		case "evdev":
+			m.Add(NewEvdevSource(config.EvdevDeviceFile(), config.EvdevButton1Keys(), config.EvdevButton2Keys(), config.EvdevDrawKeys()))
+		case "stdin":
+			m.Add(NewStdinSource())
+		case "http", "websocket":
+			m.EnablePassive(name)
+		}
+	}
+	return m
+}
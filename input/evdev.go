@@ -0,0 +1,84 @@
+package input
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+)
+
+/* Linux input event codes, see linux/input-event-codes.h */
+const (
+	evKey		= 0x01
+	keyPressed	= 1
+)
+
+/* struct input_event from linux/input.h */
+type inputEvent struct {
+	Time		syscall.Timeval
+	Type		uint16
+	Code		uint16
+	Value		int32
+}
+
+/* EvdevSource reads key presses from a Linux input device such as
+   /dev/input/event3, e.g. a USB keyboard, gamepad or arcade encoder. */
+type EvdevSource struct {
+	deviceFile	string
+	keys		map[uint16]byte
+	dev		*os.File
+}
+
+func NewEvdevSource(deviceFile string, button1Keys []uint16, button2Keys []uint16, drawKeys []uint16) *EvdevSource {
+	keys := map[uint16]byte{}
+	for _, k := range button1Keys {
+		keys[k] = '1'
+	}
+	for _, k := range button2Keys {
+		keys[k] = '2'
+	}
+	for _, k := range drawKeys {
+		keys[k] = '3'
+	}
+	return &EvdevSource{deviceFile: deviceFile, keys: keys}
+}
+
+func (s *EvdevSource) Name() string {
+	return "evdev"
+}
+
+func (s *EvdevSource) Open() error {
+	dev, err := os.Open(s.deviceFile)
+	if err != nil {
+		return err
+	}
+	s.dev = dev
+	return nil
+}
+
+func (s *EvdevSource) Read(votes chan<- Vote) error {
+	for {
+		var ev inputEvent
+		err := binary.Read(s.dev, binary.NativeEndian, &ev)
+		if err != nil {
+			return err
+		}
+		/* key release (0) and autorepeat (2) are ignored */
+		if ev.Type != evKey || ev.Value != keyPressed {
+			continue
+		}
+		button, ok := s.keys[ev.Code]
+		if !ok {
+			continue
+		}
+		votes <- Vote{Buttons: []byte{button}, Source: s.Name()}
+	}
+}
+
+func (s *EvdevSource) Close() error {
+	if s.dev == nil {
+		return nil
+	}
+	err := s.dev.Close()
+	s.dev = nil
+	return err
+}
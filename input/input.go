@@ -0,0 +1,193 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	minReconnectDelay	= 1 * time.Second
+	maxReconnectDelay	= 30 * time.Second
+)
+
+/* Vote is a chunk of button input. Buttons holds the raw bytes, of which
+   only '1', '2' and '3' (draw) are meaningful. Source names where the input came from. */
+type Vote struct {
+	Buttons		[]byte
+	Source		string
+}
+
+/* Source is an input device that is actively read from, such as the
+   serial port. Read blocks and sends everything read into votes until
+   an error occurs. A nil error from Read means the source has ended for
+   good. Otherwise the device is closed and re-opened. */
+type Source interface {
+	Name() string
+	Open() error
+	Read(votes chan<- Vote) error
+	Close() error
+}
+
+var (
+	ErrDisabled	= errors.New("input source is disabled")
+	/* the battle hasn't taken the previous input yet */
+	ErrBusy		= errors.New("input dropped, still busy with the previous one")
+)
+
+/* Status tells whether an active source is currently usable. */
+type Status struct {
+	Source		string
+	Connected	bool
+	Err		error
+}
+
+/* Mux merges all configured input sources into one channel. Sources
+   that can't be read from, like HTTP requests or websocket messages, are
+   passive and feed the mux through Push. */
+type Mux struct {
+	votes		chan Vote
+	sources		[]Source
+	passive		map[string]bool
+	lock		sync.RWMutex
+
+	onStatus	func(Status)
+	status		map[string]bool
+}
+
+func NewMux() *Mux {
+	return &Mux{
+		votes: make(chan Vote, 1),
+		passive: map[string]bool{},
+		status: map[string]bool{},
+	}
+}
+
+func (m *Mux) Add(s Source) {
+	m.sources = append(m.sources, s)
+}
+
+func (m *Mux) EnablePassive(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.passive[name] = true
+}
+
+func (m *Mux) Enabled(name string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.passive[name]
+}
+
+/* OnStatusChange registers a function that is called whenever an active
+   source gets disconnected or reconnected. Call before Start. */
+func (m *Mux) OnStatusChange(f func(Status)) {
+	m.onStatus = f
+}
+
+/* Start runs all active sources in the background. */
+func (m *Mux) Start() {
+	for _, s := range m.sources {
+		go m.run(s)
+	}
+}
+
+/* run keeps a source going, re-opening it with increasing delays after
+   read errors, e.g. when somebody pulled the USB cable. */
+func (m *Mux) run(s Source) {
+	delay := minReconnectDelay
+	for {
+		err := s.Open()
+		if err == nil {
+			delay = minReconnectDelay
+			m.setStatus(Status{Source: s.Name(), Connected: true})
+			err = s.Read(m.votes)
+			s.Close()
+			if err == nil {
+				return
+			}
+		}
+		fmt.Fprintf(os.Stderr, "input source %s: %s, retrying in %s\n", s.Name(), err, delay)
+		m.setStatus(Status{Source: s.Name(), Connected: false, Err: err})
+		time.Sleep(delay)
+		delay = delay * 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (m *Mux) setStatus(st Status) {
+	m.lock.Lock()
+	old, known := m.status[st.Source]
+	m.status[st.Source] = st.Connected
+	m.lock.Unlock()
+
+	/* only report changes, and don't report the initial connect */
+	if (known && old == st.Connected) || (!known && st.Connected) {
+		return
+	}
+	if m.onStatus != nil {
+		m.onStatus(st)
+	}
+}
+
+/* Push feeds input from a passive source. Input from sources that are
+   not enabled is dropped, and so is input that comes in while the battle
+   is busy, e.g. writing to the database, rather than holding up the
+   caller. The battle ignores input from back then anyway. */
+func (m *Mux) Push(source string, buttons []byte) error {
+	if !m.Enabled(source) {
+		return ErrDisabled
+	}
+	select {
+	case m.votes <- Vote{Buttons: buttons, Source: source}:
+		return nil
+	default:
+		return ErrBusy
+	}
+}
+
+func (m *Mux) Votes() <-chan Vote {
+	return m.votes
+}
+
+type buttonDTO struct {
+	Button		string `json:"button"`
+}
+
+/* ServeHTTP is the "http" input source: POST {"button": "1"} (or a form
+   value button=1) to vote. */
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var dto buttonDTO
+	if r.Header.Get("Content-Type") == "application/json" {
+		err := json.NewDecoder(r.Body).Decode(&dto)
+		if err != nil {
+			http.Error(w, "malformed json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		dto.Button = r.FormValue("button")
+	}
+	if dto.Button != "1" && dto.Button != "2" && dto.Button != "3" {
+		http.Error(w, "unexpected button", http.StatusBadRequest)
+		return
+	}
+	err := m.Push("http", []byte(dto.Button))
+	if errors.Is(err, ErrDisabled) {
+		http.Error(w, "http input is disabled", http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
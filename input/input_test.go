@@ -0,0 +1,76 @@
+package input
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPush(t *testing.T) {
+	m := NewMux()
+	m.EnablePassive("websocket")
+
+	if err := m.Push("http", []byte("1")); !errors.Is(err, ErrDisabled) {
+		t.Errorf("disabled source: got %v, want ErrDisabled", err)
+	}
+	if err := m.Push("websocket", []byte("1")); err != nil {
+		t.Fatalf("got %v", err)
+	}
+	/* nobody takes the first one, the second is dropped right away */
+	done := make(chan error)
+	go func() {
+		done <- m.Push("websocket", []byte("2"))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrBusy) {
+			t.Errorf("busy: got %v, want ErrBusy", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Push blocked")
+	}
+	v := <-m.Votes()
+	if string(v.Buttons) != "1" || v.Source != "websocket" {
+		t.Errorf("got %q from %s, want 1 from websocket", v.Buttons, v.Source)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	post := func(m *Mux, body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/vote", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	m := NewMux()
+	if code := post(m, `{"button": "1"}`); code != http.StatusForbidden {
+		t.Errorf("disabled: got %d, want 403", code)
+	}
+	m.EnablePassive("http")
+	for _, tc := range []struct {
+		body	string
+		code	int
+	}{
+		{`{"button": "1"}`, http.StatusNoContent},
+		/* the first one wasn't taken yet */
+		{`{"button": "2"}`, http.StatusServiceUnavailable},
+		{`{"button": "4"}`, http.StatusBadRequest},
+		{`{"button": `, http.StatusBadRequest},
+	} {
+		if code := post(m, tc.body); code != tc.code {
+			t.Errorf("%s: got %d, want %d", tc.body, code, tc.code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/vote", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got %d, want 405", w.Code)
+	}
+}
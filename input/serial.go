@@ -0,0 +1,67 @@
+package input
+
+import (
+	"os"
+	"syscall"
+)
+
+type SerialSettings struct {
+	BaudRate	int
+	Parity		string	// "none", "even" or "odd"
+	StopBits	int
+	Raw		bool
+}
+
+type SerialSource struct {
+	deviceFile	string
+	settings	SerialSettings
+	port		*os.File
+}
+
+func NewSerialSource(deviceFile string, settings SerialSettings) *SerialSource {
+	return &SerialSource{deviceFile: deviceFile, settings: settings}
+}
+
+func (s *SerialSource) Name() string {
+	return "serial"
+}
+
+func (s *SerialSource) Open() error {
+	port, err := os.OpenFile(s.deviceFile, os.O_RDONLY|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	err = configureSerialPort(port, s.settings)
+	if err != nil {
+		port.Close()
+		return err
+	}
+	s.port = port
+	return nil
+}
+
+func (s *SerialSource) Read(votes chan<- Vote) error {
+	/* buttons pressed together may come in one read, pass them all on,
+	   the battle picks what counts */
+	buf := make([]byte, 1024)
+	for {
+		count, err := s.port.Read(buf)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			b := make([]byte, count)
+			copy(b, buf[:count])
+			votes <- Vote{Buttons: b, Source: s.Name()}
+		}
+	}
+}
+
+func (s *SerialSource) Close() error {
+	if s.port == nil {
+		return nil
+	}
+	err := s.port.Close()
+	s.port = nil
+	return err
+}
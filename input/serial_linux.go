@@ -0,0 +1,74 @@
+package input
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	1200:	unix.B1200,
+	2400:	unix.B2400,
+	4800:	unix.B4800,
+	9600:	unix.B9600,
+	19200:	unix.B19200,
+	38400:	unix.B38400,
+	57600:	unix.B57600,
+	115200:	unix.B115200,
+	230400:	unix.B230400,
+}
+
+/* configureSerialPort applies the termios settings, see termios(3). */
+func configureSerialPort(port *os.File, s SerialSettings) error {
+	fd := int(port.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		/* not a tty, e.g. a pipe used for testing -> leave it alone */
+		return nil
+	}
+
+	speed, ok := baudRates[s.BaudRate]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate: %d", s.BaudRate)
+	}
+	t.Cflag &^= unix.CBAUD
+	t.Cflag |= speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+
+	t.Cflag |= unix.CLOCAL | unix.CREAD
+	t.Cflag &^= unix.CSIZE
+	t.Cflag |= unix.CS8
+
+	t.Cflag &^= unix.PARENB | unix.PARODD
+	t.Iflag &^= unix.INPCK
+	switch s.Parity {
+	case "none":
+	case "even":
+		t.Cflag |= unix.PARENB
+		t.Iflag |= unix.INPCK
+	case "odd":
+		t.Cflag |= unix.PARENB | unix.PARODD
+		t.Iflag |= unix.INPCK
+	default:
+		return fmt.Errorf("unsupported parity: %s", s.Parity)
+	}
+
+	if s.StopBits == 2 {
+		t.Cflag |= unix.CSTOPB
+	} else {
+		t.Cflag &^= unix.CSTOPB
+	}
+
+	if s.Raw {
+		/* same as cfmakeraw() */
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+	}
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package input
+
+import (
+	"os"
+)
+
+/* configureSerialPort is only implemented for Linux. Elsewhere the port
+   is used with whatever settings it already has. */
+func configureSerialPort(port *os.File, s SerialSettings) error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package input
+
+import (
+	"io"
+	"os"
+)
+
+/* StdinSource reads button input from the terminal, for development
+   without any buttons attached. Type 1 or 2 followed by enter. */
+type StdinSource struct{}
+
+func NewStdinSource() *StdinSource {
+	return &StdinSource{}
+}
+
+func (s *StdinSource) Name() string {
+	return "stdin"
+}
+
+func (s *StdinSource) Open() error {
+	return nil
+}
+
+func (s *StdinSource) Read(votes chan<- Vote) error {
+	buf := make([]byte, 1024)
+	for {
+		count, err := os.Stdin.Read(buf)
+		if err == io.EOF {
+			/* stdin closed, there won't be any more input */
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			b := make([]byte, count)
+			copy(b, buf[:count])
+			votes <- Vote{Buttons: b, Source: s.Name()}
+		}
+	}
+}
+
+func (s *StdinSource) Close() error {
+	return nil
+}
@@ -15,27 +15,287 @@ func ServerAddress() string {
 	return fmt.Sprintf("%s:%d", sa, c.Server.Port)
 }
 
+/* ServerKiosk names this kiosk in the duels it records */
+func ServerKiosk() string {
+	return Configuration().Server.Kiosk
+}
+
+func DatabaseDriver() string {
+	return Configuration().Database.Driver
+}
+
 func DatabaseConnectString() string {
 	c := Configuration()
+	if c.Database.Driver == "sqlite" {
+		/* wait for locks instead of failing right away */
+		return c.Database.File + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
 	return fmt.Sprintf("%s:%s@%s?%s", c.Database.Username, c.Database.Password, c.Database.Database, strings.Join(c.Database.Parameters, "&"))
 }
 
+/* DatabaseAutoMigrate tells whether pending schema steps are applied on
+   startup. If not, they must be applied with -migrate. */
+func DatabaseAutoMigrate() bool {
+	return *Configuration().Database.AutoMigrate
+}
+
 func ImagePath() string {
 	return Configuration().Images.Path
 }
 
+func ImageExiftool() string {
+	return Configuration().Images.Exiftool
+}
+
+/* ImageCacheDir is where generated images go, relative to ImagePath */
+func ImageCacheDir() string {
+	return Configuration().Images.CacheDir
+}
+
+func ImageJpegQuality() int {
+	return Configuration().Images.JpegQuality
+}
+
+func ImageThumbnailSize() (int, int) {
+	c := Configuration()
+	return c.Images.Thumbnail.Width, c.Images.Thumbnail.Height
+}
+
+func ImageScreenSize() (int, int) {
+	c := Configuration()
+	return c.Images.Screen.Width, c.Images.Screen.Height
+}
+
 func SerialPortDeviceFile() string {
 	return Configuration().SerialPort.DeviceFile
 }
 
+func SerialPortBaudRate() int {
+	return Configuration().SerialPort.BaudRate
+}
+
+func SerialPortParity() string {
+	return Configuration().SerialPort.Parity
+}
+
+func SerialPortStopBits() int {
+	return Configuration().SerialPort.StopBits
+}
+
+func SerialPortRawMode() bool {
+	return *Configuration().SerialPort.RawMode
+}
+
+func InputSources() []string {
+	return Configuration().Input.Sources
+}
+
+func EvdevDeviceFile() string {
+	return Configuration().Input.Evdev.DeviceFile
+}
+
+func EvdevButton1Keys() []uint16 {
+	return Configuration().Input.Evdev.Button1Keys
+}
+
+func EvdevButton2Keys() []uint16 {
+	return Configuration().Input.Evdev.Button2Keys
+}
+
+func EvdevDrawKeys() []uint16 {
+	return Configuration().Input.Evdev.DrawKeys
+}
+
+/* InputDraws tells whether a '3' or both buttons pressed within
+   InputDrawWindow count as a draw. */
+func InputDraws() bool {
+	return Configuration().Input.Draws
+}
+
+func InputDrawWindow() time.Duration {
+	return time.Duration(Configuration().Input.DrawWindowMs) * time.Millisecond
+}
+
+func HttpInputPath() string {
+	return Configuration().Input.Http.Path
+}
+
+func RatingAlgorithm() string {
+	return Configuration().Rating.Algorithm
+}
+
+func RatingGlicko2InitialDeviation() float64 {
+	return Configuration().Rating.Glicko2.InitialDeviation
+}
+
+func RatingGlicko2InitialVolatility() float64 {
+	return Configuration().Rating.Glicko2.InitialVolatility
+}
+
+func RatingGlicko2Tau() float64 {
+	return Configuration().Rating.Glicko2.Tau
+}
+
 func RatingDefaultPoints() int {
 	return Configuration().Rating.DefaultPoints
 }
 
+/* RatingFloor is the lowest rating an artwork can drop to */
+func RatingFloor() float64 {
+	return Configuration().Rating.Floor
+}
+
 func RatingKFactor() float64 {
 	return Configuration().Rating.KFactor
 }
 
+/* RatingKSchedule returns the K-factor schedule, the first matching step
+   wins. Without a match, RatingKFactor applies. */
+func RatingKSchedule() []KStepConfig {
+	return Configuration().Rating.KSchedule
+}
+
+func PairingStrategy() string {
+	return Configuration().Pairing.Strategy
+}
+
+/* PairingContenders is the number of artworks with a similar rating the
+   "similar" strategy picks from. */
+func PairingContenders() int {
+	return Configuration().Pairing.Contenders
+}
+
+/* PairingSpread is the rating difference at which the "weighted" strategy
+   picks an artwork half as often. */
+func PairingSpread() float64 {
+	return Configuration().Pairing.Spread
+}
+
+/* PairingArtworkCooldown is the number of duels an artwork sits out
+   after being shown. */
+func PairingArtworkCooldown() int {
+	return Configuration().Pairing.ArtworkCooldown
+}
+
+/* PairingPairCooldown is the number of duels before two artworks may
+   meet again. */
+func PairingPairCooldown() int {
+	return Configuration().Pairing.PairCooldown
+}
+
+func PairingNoRematches() bool {
+	return Configuration().Pairing.NoRematches
+}
+
+/* PairingSeparateArtists tells whether works by the same artist are kept
+   apart. */
+func PairingSeparateArtists() bool {
+	return Configuration().Pairing.SeparateArtists
+}
+
+/* CategoryNames lists the configured categories in the order the battle
+   takes turns, the fallback last unless it is listed. Empty if artworks
+   are not split into categories. */
+func CategoryNames() []string {
+	var names []string
+	listed := false
+	for _, c := range Configuration().Categories.List {
+		names = append(names, c.Name)
+		listed = listed || strings.EqualFold(c.Name, Configuration().Categories.Fallback)
+	}
+	if len(names) > 0 && !listed {
+		names = append(names, Configuration().Categories.Fallback)
+	}
+	return names
+}
+
+/* CategoryFallback is the category of artworks that belong to none of
+   the listed ones, "" if artworks are not split into categories. */
+func CategoryFallback() string {
+	c := Configuration().Categories
+	if len(c.List) == 0 {
+		return ""
+	}
+	for _, category := range c.List {
+		if strings.EqualFold(category.Name, c.Fallback) {
+			return category.Name
+		}
+	}
+	return c.Fallback
+}
+
+/* CategoryDuelsPerTurn is the number of duels in a row drawn from one
+   category before the next one takes its turn. */
+func CategoryDuelsPerTurn() int {
+	return Configuration().Categories.DuelsPerTurn
+}
+
+/* CategoryForPanel returns the first listed category with a prefix of
+   the panel number, or "" if there is none. */
+func CategoryForPanel(panel string) string {
+	for _, c := range Configuration().Categories.List {
+		for _, prefix := range c.PanelPrefixes {
+			if strings.HasPrefix(panel, prefix) {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+/* PairingMaxSpread is how many duels an artwork may get ahead of the
+   one with the fewest duels, artworks that are catching up aside. 0
+   means no limit. */
+func PairingMaxSpread() int {
+	return *Configuration().Pairing.MaxSpread
+}
+
+/* PairingCatchUpShare is the share of duels in which artworks that are
+   catching up may take part. */
+func PairingCatchUpShare() float64 {
+	return Configuration().Pairing.CatchUpShare
+}
+
+/* PairingPrefetch is the number of duels paired ahead of time, 0 to pair
+   each duel only when it is due. */
+func PairingPrefetch() int {
+	return Configuration().Pairing.Prefetch
+}
+
+/* PairingPrefetchTolerance is how far the rating of an artwork may move
+   before duels paired ahead of time with it are paired anew. */
+func PairingPrefetchTolerance() float64 {
+	return Configuration().Pairing.PrefetchTolerance
+}
+
+/* FinalsEnabled switches the battle from open duels to the finals
+   bracket. */
+func FinalsEnabled() bool {
+	return Configuration().Finals.Enabled
+}
+
+/* FinalsFormat is "single" or "double" elimination. */
+func FinalsFormat() string {
+	return Configuration().Finals.Format
+}
+
+/* FinalsSize is the number of artworks from the top of the leaderboard
+   that take part in the finals. */
+func FinalsSize() int {
+	return Configuration().Finals.Size
+}
+
+/* FinalsBestOf is the number of votes a match lasts at most. */
+func FinalsBestOf() int {
+	return Configuration().Finals.BestOf
+}
+
+/* FinalsCategory is the category whose leaderboard is seeded, "" for
+   all artworks. */
+func FinalsCategory() string {
+	return Configuration().Finals.Category
+}
+
 func TimingsDuelTimeout() time.Duration {
 	return time.Duration(Configuration().Timing.DuelTimeout)
 }
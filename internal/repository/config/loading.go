@@ -30,12 +30,12 @@ func ParseCommingLineFlags() {
 	flag.Parse()
 }
 
-func parseAndOverwriteConfig(yamlFile []byte) error {
+func parseConfig(yamlFile []byte) (*Application, error) {
 	newConfigurationData := &Application{}
 	err := yaml.Unmarshal(yamlFile, newConfigurationData)
 	if err != nil {
 		aulogging.Logger.NoCtx().Error().Print("Failed to parse configuration: %v", err)
-		return err
+		return nil, err
 	}
 	setConfigurationDefaults(newConfigurationData)
 	applyEnvVarOverrides(newConfigurationData)
@@ -45,7 +45,11 @@ func parseAndOverwriteConfig(yamlFile []byte) error {
 	validateServerConfiguration(errs, newConfigurationData.Server)
 	validateDatabaseConfiguration(errs, newConfigurationData.Database)
 	validateSerialPortConfiguration(errs, newConfigurationData.SerialPort)
+	validateInputConfiguration(errs, newConfigurationData.Input, newConfigurationData.SerialPort)
 	validateRatingConfiguration(errs, newConfigurationData.Rating)
+	validatePairingConfiguration(errs, newConfigurationData.Pairing)
+	validateCategoriesConfiguration(errs, newConfigurationData.Categories)
+	validateFinalsConfiguration(errs, newConfigurationData.Finals, newConfigurationData.Categories)
 	validateImageConfiguration(errs, newConfigurationData.Images)
 	validateTimingConfiguration(errs, newConfigurationData.Timing)
 	if len(errs) != 0 {
@@ -60,7 +64,15 @@ func parseAndOverwriteConfig(yamlFile []byte) error {
 			val := errs[k]
 			aulogging.Logger.NoCtx().Error().Printf("configuration error: %s: %s", key, val[0])
 		}
-		return errors.New("configuration validation error")
+		return nil, errors.New("configuration validation error")
+	}
+	return newConfigurationData, nil
+}
+
+func parseAndOverwriteConfig(yamlFile []byte) error {
+	newConfigurationData, err := parseConfig(yamlFile)
+	if err != nil {
+		return err
 	}
 
 	/* safely exchange old config for new config */
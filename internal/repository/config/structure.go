@@ -5,34 +5,126 @@ type (
 		Server		ServerConfig		`yaml:"server"`
 		Database	DatabaseConfig		`yaml:"database"`
 		SerialPort	SerialPortConfig	`yaml:"serial_port"`
+		Input		InputConfig		`yaml:"input"`
 		Rating		RatingConfig		`yaml:"rating"`
-		Images		ImageConfig		`yaml:images"`
-		Timing		TimingConfig		`yaml"timings"`
+		Pairing		PairingConfig		`yaml:"pairing"`
+		Categories	CategoriesConfig	`yaml:"categories"`
+		Finals		FinalsConfig		`yaml:"finals"`
+		Images		ImageConfig		`yaml:"images"`
+		Timing		TimingConfig		`yaml:"timings"`
 	}
 
 	ServerConfig struct {
 		Address		string			`yaml:"address"`
 		Port		int			`yaml:"port"`
+		Kiosk		string			`yaml:"kiosk"`
 	}
 
 	DatabaseConfig struct {
+		Driver		string			`yaml:"driver"`
+		File		string			`yaml:"file"`
 		Username	string			`yaml:"username"`
 		Password	string			`yaml:"password"`
 		Database	string			`yaml:"database"`
 		Parameters	[]string		`yaml:"parameters"`
+		AutoMigrate	*bool			`yaml:"auto_migrate"`
 	}
 
 	SerialPortConfig struct {
 		DeviceFile	string			`yaml:"device_file"`
+		BaudRate	int			`yaml:"baud_rate"`
+		Parity		string			`yaml:"parity"`
+		StopBits	int			`yaml:"stop_bits"`
+		RawMode		*bool			`yaml:"raw_mode"`
+	}
+
+	InputConfig struct {
+		Sources		[]string		`yaml:"sources"`
+		Evdev		EvdevConfig		`yaml:"evdev"`
+		Http		HttpInputConfig		`yaml:"http"`
+		Draws		bool			`yaml:"draws"`
+		DrawWindowMs	int			`yaml:"draw_window_ms"`
+	}
+
+	EvdevConfig struct {
+		DeviceFile	string			`yaml:"device_file"`
+		Button1Keys	[]uint16		`yaml:"button1_keys"`
+		Button2Keys	[]uint16		`yaml:"button2_keys"`
+		DrawKeys	[]uint16		`yaml:"draw_keys"`
+	}
+
+	HttpInputConfig struct {
+		Path		string			`yaml:"path"`
 	}
 
 	RatingConfig struct {
+		Algorithm	string			`yaml:"algorithm"`
 		DefaultPoints	int			`yaml:"default_points"`
+		Floor		float64			`yaml:"floor"`
+		KFactor		float64			`yaml:"k_factor"`
+		KSchedule	[]KStepConfig		`yaml:"k_schedule"`
+		Glicko2		Glicko2Config		`yaml:"glicko2"`
+	}
+
+	/* limits that are 0 don't apply */
+	KStepConfig struct {
+		MaxDuels	uint64			`yaml:"max_duels"`
+		MinRating	float64			`yaml:"min_rating"`
+		MaxRating	float64			`yaml:"max_rating"`
 		KFactor		float64			`yaml:"k_factor"`
 	}
 
+	PairingConfig struct {
+		Strategy	string			`yaml:"strategy"`
+		Contenders	int			`yaml:"contenders"`
+		Spread		float64			`yaml:"spread"`
+		ArtworkCooldown	int			`yaml:"artwork_cooldown"`
+		PairCooldown	int			`yaml:"pair_cooldown"`
+		NoRematches	bool			`yaml:"no_rematches"`
+		SeparateArtists	bool			`yaml:"separate_artists"`
+		MaxSpread	*int			`yaml:"max_spread"`
+		CatchUpShare	float64			`yaml:"catch_up_share"`
+		Prefetch	int			`yaml:"prefetch"`
+		PrefetchTolerance	float64		`yaml:"prefetch_tolerance"`
+	}
+
+	CategoriesConfig struct {
+		DuelsPerTurn	int			`yaml:"duels_per_turn"`
+		List		[]CategoryConfig	`yaml:"list"`
+		Fallback	string			`yaml:"fallback"`
+	}
+
+	CategoryConfig struct {
+		Name		string			`yaml:"name"`
+		PanelPrefixes	[]string		`yaml:"panel_prefixes"`
+	}
+
+	FinalsConfig struct {
+		Enabled		bool			`yaml:"enabled"`
+		Format		string			`yaml:"format"`
+		Size		int			`yaml:"size"`
+		BestOf		int			`yaml:"best_of"`
+		Category	string			`yaml:"category"`
+	}
+
+	Glicko2Config struct {
+		InitialDeviation	float64		`yaml:"initial_deviation"`
+		InitialVolatility	float64		`yaml:"initial_volatility"`
+		Tau			float64		`yaml:"tau"`
+	}
+
 	ImageConfig struct {
 		Path		string			`yaml:"path"`
+		Exiftool	string			`yaml:"exiftool"`
+		CacheDir	string			`yaml:"cache_dir"`
+		JpegQuality	int			`yaml:"jpeg_quality"`
+		Thumbnail	ImageSizeConfig		`yaml:"thumbnail"`
+		Screen		ImageSizeConfig		`yaml:"screen"`
+	}
+
+	ImageSizeConfig struct {
+		Width		int			`yaml:"width"`
+		Height		int			`yaml:"height"`
 	}
 
 	TimingConfig struct {
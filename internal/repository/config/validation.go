@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"slices"
 	"strings"
 	"net/url"
 	"os"
@@ -10,12 +12,113 @@ func setConfigurationDefaults(c *Application) {
 	if c.Server.Port == 0 {
 		c.Server.Port = 5000
 	}
+	if c.Server.Kiosk == "" {
+		host, _ := os.Hostname()
+		c.Server.Kiosk = host[:min(len(host), 40)]
+	}
+	if c.Database.Driver == "" {
+		c.Database.Driver = "mysql"
+	}
+	if c.SerialPort.BaudRate == 0 {
+		c.SerialPort.BaudRate = 9600
+	}
+	if c.SerialPort.Parity == "" {
+		c.SerialPort.Parity = "none"
+	}
+	if c.SerialPort.StopBits == 0 {
+		c.SerialPort.StopBits = 1
+	}
+	if c.Database.AutoMigrate == nil {
+		auto := true
+		c.Database.AutoMigrate = &auto
+	}
+	if c.SerialPort.RawMode == nil {
+		raw := true
+		c.SerialPort.RawMode = &raw
+	}
+	if len(c.Input.Sources) == 0 {
+		c.Input.Sources = []string{"serial", "websocket"}
+	}
+	/* KEY_1, KEY_KP1, KEY_LEFT, BTN_TRIGGER, BTN_SOUTH */
+	if len(c.Input.Evdev.Button1Keys) == 0 {
+		c.Input.Evdev.Button1Keys = []uint16{2, 79, 105, 288, 304}
+	}
+	/* KEY_2, KEY_KP2, KEY_RIGHT, BTN_THUMB, BTN_EAST */
+	if len(c.Input.Evdev.Button2Keys) == 0 {
+		c.Input.Evdev.Button2Keys = []uint16{3, 80, 106, 289, 305}
+	}
+	if c.Input.DrawWindowMs == 0 {
+		c.Input.DrawWindowMs = 300
+	}
+	if c.Input.Http.Path == "" {
+		c.Input.Http.Path = "/vote"
+	}
+	if c.Rating.Algorithm == "" {
+		c.Rating.Algorithm = "elo"
+	}
+	if c.Rating.Glicko2.InitialDeviation == 0 {
+		c.Rating.Glicko2.InitialDeviation = 350
+	}
+	if c.Rating.Glicko2.InitialVolatility == 0 {
+		c.Rating.Glicko2.InitialVolatility = 0.06
+	}
+	if c.Rating.Glicko2.Tau == 0 {
+		c.Rating.Glicko2.Tau = 0.5
+	}
 	if c.Rating.DefaultPoints == 0 {
 		c.Rating.DefaultPoints = 800
 	}
 	if c.Rating.KFactor == 0 {
 		c.Rating.KFactor = 16
 	}
+	if c.Pairing.Strategy == "" {
+		c.Pairing.Strategy = "similar"
+	}
+	if c.Pairing.Contenders == 0 {
+		c.Pairing.Contenders = 50
+	}
+	if c.Pairing.Spread == 0 {
+		c.Pairing.Spread = 200
+	}
+	if c.Pairing.MaxSpread == nil {
+		/* 0 turns the limit off, so only a missing setting gets this */
+		spread := 3
+		c.Pairing.MaxSpread = &spread
+	}
+	if c.Pairing.CatchUpShare == 0 {
+		c.Pairing.CatchUpShare = 0.5
+	}
+	if c.Pairing.PrefetchTolerance == 0 {
+		c.Pairing.PrefetchTolerance = 25
+	}
+	if c.Categories.DuelsPerTurn == 0 {
+		c.Categories.DuelsPerTurn = 5
+	}
+	if c.Categories.Fallback == "" {
+		c.Categories.Fallback = "other"
+	}
+	if c.Finals.Format == "" {
+		c.Finals.Format = "single"
+	}
+	if c.Finals.Size == 0 {
+		c.Finals.Size = 8
+	}
+	if c.Finals.BestOf == 0 {
+		c.Finals.BestOf = 3
+	}
+	if c.Images.CacheDir == "" {
+		c.Images.CacheDir = ".cache/"
+	}
+	if c.Images.CacheDir[len(c.Images.CacheDir)-1:] != "/" {
+		c.Images.CacheDir = c.Images.CacheDir + "/"
+	}
+	if c.Images.JpegQuality == 0 {
+		c.Images.JpegQuality = 85
+	}
+	if c.Images.Thumbnail.Width == 0 && c.Images.Thumbnail.Height == 0 {
+		c.Images.Thumbnail.Width = 400
+		c.Images.Thumbnail.Height = 400
+	}
 	if c.Timing.DuelTimeout == 0 {
 		c.Timing.DuelTimeout = 20
 	}
@@ -42,9 +145,26 @@ func validateServerConfiguration(errs url.Values, c ServerConfig) {
 	if c.Port < 1 || c.Port > 65535 {
 		errs.Add("server.port", "must be a number between 1 and 65535")
 	}
+	if len(c.Kiosk) > 40 {
+		errs.Add("server.kiosk", "must be at most 40 characters long. Default: the host name")
+	}
 }
 
 func validateDatabaseConfiguration(errs url.Values, c DatabaseConfig) {
+	if c.Driver == "memory" {
+		/* nothing to configure, and nothing survives a restart */
+		return
+	}
+	if c.Driver == "sqlite" {
+		if c.File == "" || strings.Contains(c.File, "?") {
+			errs.Add("database.file", "must be the path of the SQLite database file, e.g. 'artbattle.db'")
+		}
+		return
+	}
+	if c.Driver != "mysql" {
+		errs.Add("database.driver", "must be mysql, sqlite or memory. Default: mysql")
+		return
+	}
 	if len(c.Username) < 1 || len(c.Username) > 256 {
 		errs.Add("database.username", "must be between 1 and 256 characters long")
 	}
@@ -56,18 +176,162 @@ func validateDatabaseConfiguration(errs url.Values, c DatabaseConfig) {
 	}
 }
 
+var supportedBaudRates = []int{1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200, 230400}
+
 func validateSerialPortConfiguration(errs url.Values, c SerialPortConfig) {
-	if c.DeviceFile == "" {
+	if !slices.Contains(supportedBaudRates, c.BaudRate) {
+		errs.Add("serial_port.baud_rate", "must be one of 1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200, 230400. Default: 9600")
+	}
+	if c.Parity != "none" && c.Parity != "even" && c.Parity != "odd" {
+		errs.Add("serial_port.parity", "must be one of none, even, odd. Default: none")
+	}
+	if c.StopBits != 1 && c.StopBits != 2 {
+		errs.Add("serial_port.stop_bits", "must be 1 or 2. Default: 1")
+	}
+}
+
+var knownInputSources = []string{"serial", "evdev", "stdin", "http", "websocket"}
+
+/* paths served by main.go, the http input source must keep clear of them */
+var reservedHttpPaths = []string{"/", "/ws", "/images/", "/api/"}
+
+func httpPathTaken(path string) bool {
+	for _, p := range reservedHttpPaths {
+		if path == p || (strings.HasSuffix(p, "/") && p != "/" && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateInputConfiguration(errs url.Values, c InputConfig, sp SerialPortConfig) {
+	for _, name := range c.Sources {
+		if !slices.Contains(knownInputSources, name) {
+			errs.Add("input.sources", "unknown input source '" + name + "', must be one of " + strings.Join(knownInputSources, ", "))
+		}
+	}
+	if slices.Contains(c.Sources, "serial") && sp.DeviceFile == "" {
 		errs.Add("serial_port.device_file", "must be a filename for a serial device file, such as /dev/tty/1")
 	}
+	if slices.Contains(c.Sources, "evdev") && c.Evdev.DeviceFile == "" {
+		errs.Add("input.evdev.device_file", "must be a filename for an input event device, such as /dev/input/event3")
+	}
+	if c.Http.Path == "" || c.Http.Path[0] != '/' || strings.ContainsAny(c.Http.Path, " {}") {
+		errs.Add("input.http.path", "must be an URL path starting with '/', without spaces or braces. Default: /vote")
+	} else if httpPathTaken(c.Http.Path) {
+		errs.Add("input.http.path", "is taken, must not be / or /ws, or below /images/ or /api/. Default: /vote")
+	}
+	if c.DrawWindowMs < 10 || c.DrawWindowMs > 2000 {
+		errs.Add("input.draw_window_ms", "must be a number between 10 and 2000. Default: 300")
+	}
 }
 
 func validateRatingConfiguration(errs url.Values, c RatingConfig) {
 	if c.DefaultPoints < 1 || c.DefaultPoints > 10000 {
 		errs.Add("rating.default_points", "must be a number between 1 and 10000. Default: 800")
 	}
+	if c.Floor < 0 || c.Floor >= float64(c.DefaultPoints) {
+		errs.Add("rating.floor", "must be a number from 0 up to below default_points. Default: 0")
+	}
 	if c.KFactor < 1 || c.KFactor > 100 {
-		errs.Add("rating.default_points", "must be a number between 1 and 100. Default: 16")
+		errs.Add("rating.k_factor", "must be a number between 1 and 100. Default: 16")
+	}
+	for i, step := range c.KSchedule {
+		key := fmt.Sprintf("rating.k_schedule[%d]", i)
+		if step.KFactor < 1 || step.KFactor > 100 {
+			errs.Add(key + ".k_factor", "must be a number between 1 and 100")
+		}
+		if step.MaxRating != 0 && step.MinRating > step.MaxRating {
+			errs.Add(key + ".min_rating", "must not be above max_rating")
+		}
+		if step.MaxDuels == 0 && step.MinRating == 0 && step.MaxRating == 0 {
+			errs.Add(key, "needs at least one of max_duels, min_rating or max_rating")
+		}
+	}
+	if c.Algorithm != "elo" && c.Algorithm != "glicko2" {
+		errs.Add("rating.algorithm", "must be elo or glicko2. Default: elo")
+	}
+	if c.Glicko2.InitialDeviation < 30 || c.Glicko2.InitialDeviation > 500 {
+		errs.Add("rating.glicko2.initial_deviation", "must be a number between 30 and 500. Default: 350")
+	}
+	if c.Glicko2.InitialVolatility <= 0 || c.Glicko2.InitialVolatility > 1 {
+		errs.Add("rating.glicko2.initial_volatility", "must be a number above 0 and at most 1. Default: 0.06")
+	}
+	if c.Glicko2.Tau < 0.2 || c.Glicko2.Tau > 2 {
+		errs.Add("rating.glicko2.tau", "must be a number between 0.2 and 2. Default: 0.5")
+	}
+}
+
+var knownPairingStrategies = []string{"similar", "weighted", "swiss", "information"}
+
+func validatePairingConfiguration(errs url.Values, c PairingConfig) {
+	if !slices.Contains(knownPairingStrategies, c.Strategy) {
+		errs.Add("pairing.strategy", "must be one of " + strings.Join(knownPairingStrategies, ", ") + ". Default: similar")
+	}
+	if c.Contenders < 1 || c.Contenders > 1000 {
+		errs.Add("pairing.contenders", "must be a number between 1 and 1000. Default: 50")
+	}
+	if c.Spread < 1 || c.Spread > 10000 {
+		errs.Add("pairing.spread", "must be a number between 1 and 10000. Default: 200")
+	}
+	if c.ArtworkCooldown < 0 || c.ArtworkCooldown > 100 {
+		errs.Add("pairing.artwork_cooldown", "must be a number between 0 and 100. Default: 0")
+	}
+	if c.PairCooldown < 0 || c.PairCooldown > 10000 {
+		errs.Add("pairing.pair_cooldown", "must be a number between 0 and 10000. Default: 0")
+	}
+	if *c.MaxSpread < 0 || *c.MaxSpread > 1000 {
+		errs.Add("pairing.max_spread", "must be a number between 0 and 1000. Default: 3")
+	}
+	if c.CatchUpShare <= 0 || c.CatchUpShare > 1 {
+		errs.Add("pairing.catch_up_share", "must be a number above 0 and at most 1. Default: 0.5")
+	}
+	if c.Prefetch < 0 || c.Prefetch > 20 {
+		errs.Add("pairing.prefetch", "must be a number between 0 and 20. Default: 0")
+	}
+	if c.PrefetchTolerance <= 0 || c.PrefetchTolerance > 10000 {
+		errs.Add("pairing.prefetch_tolerance", "must be a number above 0 and at most 10000. Default: 25")
+	}
+}
+
+func validateCategoriesConfiguration(errs url.Values, c CategoriesConfig) {
+	if c.DuelsPerTurn < 1 || c.DuelsPerTurn > 1000 {
+		errs.Add("categories.duels_per_turn", "must be a number between 1 and 1000. Default: 5")
+	}
+	seen := map[string]bool{}
+	for i, category := range c.List {
+		key := fmt.Sprintf("categories.list[%d].name", i)
+		if category.Name == "" || len(category.Name) > 40 {
+			errs.Add(key, "must be between 1 and 40 characters long")
+		}
+		if seen[strings.ToLower(category.Name)] {
+			errs.Add(key, "must be unique")
+		}
+		seen[strings.ToLower(category.Name)] = true
+	}
+	if len(c.Fallback) > 40 {
+		errs.Add("categories.fallback", "must be between 1 and 40 characters long. Default: other")
+	}
+}
+
+func validateFinalsConfiguration(errs url.Values, c FinalsConfig, categories CategoriesConfig) {
+	if c.Format != "single" && c.Format != "double" {
+		errs.Add("finals.format", "must be single or double. Default: single")
+	}
+	if !slices.Contains([]int{2, 4, 8, 16, 32, 64}, c.Size) || (c.Format == "double" && c.Size < 4) {
+		errs.Add("finals.size", "must be a power of two between 2 and 64, at least 4 for double elimination. Default: 8")
+	}
+	if c.BestOf < 1 || c.BestOf > 15 || c.BestOf % 2 == 0 {
+		errs.Add("finals.best_of", "must be an odd number between 1 and 15. Default: 3")
+	}
+	if c.Category != "" {
+		found := c.Category == categories.Fallback
+		for _, category := range categories.List {
+			found = found || category.Name == c.Category
+		}
+		if !found {
+			errs.Add("finals.category", "must be one of the names in categories.list or categories.fallback, or empty for all artworks")
+		}
 	}
 }
 
@@ -82,6 +346,27 @@ func validateImageConfiguration(errs url.Values, c ImageConfig) {
 	if strings.Contains(c.Path, "../") {
 		errs.Add("images.path", "can't use path element '../', please use wouldn't work in URLs")
 	}
+	if strings.HasPrefix(c.CacheDir, "/") || strings.Contains(c.CacheDir, "../") {
+		errs.Add("images.cache_dir", "must be a directory below images.path, e.g. '.cache/'")
+	}
+	if c.JpegQuality < 1 || c.JpegQuality > 100 {
+		errs.Add("images.jpeg_quality", "must be a number between 1 and 100. Default: 85")
+	}
+	validateImageSize(errs, "images.thumbnail", c.Thumbnail)
+	validateImageSize(errs, "images.screen", c.Screen)
+}
+
+func validateImageSize(errs url.Values, key string, c ImageSizeConfig) {
+	/* 0x0 means "don't generate" */
+	if c.Width == 0 && c.Height == 0 {
+		return
+	}
+	if c.Width < 16 || c.Width > 10000 {
+		errs.Add(key + ".width", "must be a number between 16 and 10000, or 0 together with height to disable")
+	}
+	if c.Height < 16 || c.Height > 10000 {
+		errs.Add(key + ".height", "must be a number between 16 and 10000, or 0 together with width to disable")
+	}
 }
 
 func validateTimingConfiguration(errs url.Values, c TimingConfig) {
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/fsnotify/fsnotify"
+)
+
+/* editors tend to write files in several steps, so wait for things to
+   settle down before reloading */
+const reloadDelay = 500 * time.Millisecond
+
+/* StartWatchingConfiguration reloads the configuration file whenever it
+   changes. Invalid configurations are logged and ignored. */
+func StartWatchingConfiguration() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	/* watch the directory rather than the file itself, so we notice
+	   editors that replace the file by renaming a new one over it */
+	err = watcher.Add(filepath.Dir(configurationFilename))
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filepath.Clean(configurationFilename) {
+					continue
+				}
+				if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Rename) {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, reloadConfiguration)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				aulogging.Logger.NoCtx().Error().Printf("configuration watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func reloadConfiguration() {
+	yamlFile, err := os.ReadFile(configurationFilename)
+	if err != nil {
+		aulogging.Logger.NoCtx().Error().Printf("Failed to reload configuration file '%s', keeping previous configuration: %v", configurationFilename, err)
+		return
+	}
+	newConfigurationData, err := parseConfig(yamlFile)
+	if err != nil {
+		aulogging.Logger.NoCtx().Error().Printf("Invalid configuration in '%s', keeping previous configuration: %v", configurationFilename, err)
+		return
+	}
+
+	configurationLock.Lock()
+	defer configurationLock.Unlock()
+	keepRestartOnlySettings(configurationData, newConfigurationData)
+	configurationData = newConfigurationData
+	aulogging.Logger.NoCtx().Info().Printf("Reloaded configuration from '%s'", configurationFilename)
+}
+
+/* Some sections are only read at startup. Changes to them are flagged, and
+   the running values are kept until the next restart. */
+func keepRestartOnlySettings(current *Application, changed *Application) {
+	warn := func(section string) {
+		aulogging.Logger.NoCtx().Warn().Printf("configuration section '%s' changed, this requires a restart to take effect", section)
+	}
+	if !reflect.DeepEqual(current.Server, changed.Server) {
+		warn("server")
+		changed.Server = current.Server
+	}
+	if !reflect.DeepEqual(current.Database, changed.Database) {
+		warn("database")
+		changed.Database = current.Database
+	}
+	if !reflect.DeepEqual(current.SerialPort, changed.SerialPort) {
+		warn("serial_port")
+		changed.SerialPort = current.SerialPort
+	}
+	if !reflect.DeepEqual(current.Input, changed.Input) {
+		warn("input")
+		changed.Input = current.Input
+	}
+	/* categories are assigned while scanning the images */
+	if !reflect.DeepEqual(current.Categories, changed.Categories) {
+		warn("categories")
+		changed.Categories = current.Categories
+	}
+	if !reflect.DeepEqual(current.Images, changed.Images) {
+		warn("images")
+		changed.Images = current.Images
+	}
+}
@@ -0,0 +1,73 @@
+package pairing
+
+import (
+	"math"
+	"sort"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* ExposureStats tells how evenly the duels are spread over the artworks
+   of a category. */
+type ExposureStats struct {
+	Category	string
+	Artworks	int
+	MinDuels	uint64
+	MaxDuels	uint64
+	MeanDuels	float64
+	StdDev		float64
+	/* MaxDuels - MinDuels, at most pairing.max_spread once no artwork
+	   is catching up any more */
+	Spread		uint64
+	/* artworks more than pairing.max_spread duels behind MaxDuels */
+	CatchingUp	int
+}
+
+/* catchingUp tells whether an artwork is so far behind the one with the
+   most duels that the spread limit doesn't apply to it */
+func catchingUp(a *database.Artwork, most uint64, spread uint64) bool {
+	return spread > 0 && a.DuelCount + spread < most
+}
+
+/* Exposure returns the duel count statistics of each category, ordered by
+   name. */
+func Exposure(all []*database.Artwork) []ExposureStats {
+	byCategory := map[string][]*database.Artwork{}
+	for _, a := range all {
+		byCategory[a.Category] = append(byCategory[a.Category], a)
+	}
+	var res []ExposureStats
+	for category, artworks := range byCategory {
+		res = append(res, exposureOf(category, artworks, uint64(config.PairingMaxSpread())))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Category < res[j].Category })
+	return res
+}
+
+func exposureOf(category string, artworks []*database.Artwork, spread uint64) ExposureStats {
+	s := ExposureStats{Category: category, Artworks: len(artworks)}
+	total := 0.0
+	for i, a := range artworks {
+		if i == 0 || a.DuelCount < s.MinDuels {
+			s.MinDuels = a.DuelCount
+		}
+		if a.DuelCount > s.MaxDuels {
+			s.MaxDuels = a.DuelCount
+		}
+		total = total + float64(a.DuelCount)
+	}
+	s.MeanDuels = total / float64(len(artworks))
+	s.Spread = s.MaxDuels - s.MinDuels
+
+	squares := 0.0
+	for _, a := range artworks {
+		d := float64(a.DuelCount) - s.MeanDuels
+		squares = squares + d * d
+		if catchingUp(a, s.MaxDuels, spread) {
+			s.CatchingUp = s.CatchingUp + 1
+		}
+	}
+	s.StdDev = math.Sqrt(squares / float64(len(artworks)))
+	return s
+}
@@ -0,0 +1,321 @@
+package pairing
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* History remembers which artworks were shown together recently, so
+   the same ones don't come up again right away. */
+type History struct {
+	lock	sync.Mutex
+	/* duels seen so far */
+	count	int
+	/* number of the last duel of an artwork, and of a pair */
+	last	map[uint]int
+	pairs	map[[2]uint]int
+}
+
+func NewHistory() *History {
+	return &History{
+		last: map[uint]int{},
+		pairs: map[[2]uint]int{},
+	}
+}
+
+func pairKey(a, b uint) [2]uint {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]uint{a, b}
+}
+
+/* Seed adds the duels from the database, oldest first. */
+func (h *History) Seed(duels []*database.Duel) {
+	for _, d := range duels {
+		h.Add(d.Duelist1, d.Duelist2)
+	}
+}
+
+/* Add records that two artworks were shown together. */
+func (h *History) Add(a, b uint) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.count = h.count + 1
+	h.last[a] = h.count
+	h.last[b] = h.count
+	h.pairs[pairKey(a, b)] = h.count
+}
+
+/* Clone returns a copy that can be added to without changing h, e.g. to
+   plan duels ahead. */
+func (h *History) Clone() *History {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	c := &History{
+		count: h.count,
+		last: make(map[uint]int, len(h.last)),
+		pairs: make(map[[2]uint]int, len(h.pairs)),
+	}
+	for id, n := range h.last {
+		c.last[id] = n
+	}
+	for p, n := range h.pairs {
+		c.pairs[p] = n
+	}
+	return c
+}
+
+/* Exclusions returns what to avoid in the next duel, according to the
+   current pairing settings. With a category, only artworks of that
+   category take part. */
+func (h *History) Exclusions(category string) *Exclusions {
+	return &Exclusions{
+		category: category,
+		history: h,
+		artworkCooldown: config.PairingArtworkCooldown(),
+		pairCooldown: config.PairingPairCooldown(),
+		noRematches: config.PairingNoRematches(),
+		skip: map[uint]bool{},
+	}
+}
+
+/* Exclusions tell a strategy which artworks and pairs to avoid: artworks
+   shown in the last artworkCooldown duels, pairs that met in the last
+   pairCooldown duels, or at all with noRematches, and with artists set,
+   pairs by the same artist. Artworks outside of category, if set, never
+   take part. With spread set, see balance. */
+type Exclusions struct {
+	category	string
+	history		*History
+	artworkCooldown	int
+	pairCooldown	int
+	noRematches	bool
+	/* normalized artist by artwork id */
+	artists		map[uint]string
+	/* artworks that found no partner, don't try them again */
+	skip		map[uint]bool
+
+	spread		uint64
+	/* fewest duels of an artwork that isn't catching up, and whether
+	   more than one has that few */
+	lowest		uint64
+	lowestShared	bool
+	/* artworks that are catching up, and whether they sit this one out */
+	behind		map[uint]bool
+	holdBack	bool
+}
+
+/* Category is the category all artworks must be in, "" for any. */
+func (e *Exclusions) Category() string {
+	if e == nil {
+		return ""
+	}
+	return e.category
+}
+
+/* Artwork tells whether an artwork must not take part at all. */
+func (e *Exclusions) Artwork(a *database.Artwork) bool {
+	if e == nil {
+		return false
+	}
+	if e.skip[a.ID] || (e.category != "" && a.Category != e.category) || e.held(a.ID) {
+		return true
+	}
+	if e.history == nil {
+		return false
+	}
+	e.history.lock.Lock()
+	defer e.history.lock.Unlock()
+	last, ok := e.history.last[a.ID]
+	return ok && e.history.count - last < e.artworkCooldown
+}
+
+/* Pair tells whether two artworks must not meet. */
+func (e *Exclusions) Pair(a, b uint) bool {
+	if e == nil {
+		return false
+	}
+	if e.artists[a] != "" && e.artists[a] == e.artists[b] {
+		return true
+	}
+	if e.history == nil {
+		return false
+	}
+	e.history.lock.Lock()
+	defer e.history.lock.Unlock()
+	last, ok := e.history.pairs[pairKey(a, b)]
+	if !ok {
+		return false
+	}
+	return e.noRematches || e.history.count - last < e.pairCooldown
+}
+
+/* Artworks lists the ids of all artworks that must not take part, apart
+   from those outside of the category. */
+func (e *Exclusions) Artworks() []uint {
+	if e == nil {
+		return nil
+	}
+	var res []uint
+	for id := range e.skip {
+		res = append(res, id)
+	}
+	for id := range e.behind {
+		if e.held(id) && !e.skip[id] {
+			res = append(res, id)
+		}
+	}
+	if e.history != nil {
+		e.history.lock.Lock()
+		for id, last := range e.history.last {
+			if e.history.count - last < e.artworkCooldown && !e.skip[id] && !e.held(id) {
+				res = append(res, id)
+			}
+		}
+		e.history.lock.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+/* Partners lists the ids of all artworks that must not meet a. */
+func (e *Exclusions) Partners(a uint) []uint {
+	if e == nil {
+		return nil
+	}
+	res := e.Artworks()
+	if e.artists[a] != "" {
+		for id, artist := range e.artists {
+			if id != a && artist == e.artists[a] {
+				res = append(res, id)
+			}
+		}
+	}
+	if e.history != nil {
+		e.history.lock.Lock()
+		for p := range e.history.pairs {
+			var other uint
+			if p[0] == a {
+				other = p[1]
+			} else if p[1] == a {
+				other = p[0]
+			} else {
+				continue
+			}
+			last := e.history.pairs[p]
+			if e.noRematches || e.history.count - last < e.pairCooldown {
+				res = append(res, other)
+			}
+		}
+		e.history.lock.Unlock()
+	}
+	return res
+}
+
+/* DuelsBelow returns the number of duels a partner of first must stay
+   below, so the spread of duel counts stays within limits after the
+   duel. 0 means no limit. */
+func (e *Exclusions) DuelsBelow(first *database.Artwork) uint64 {
+	if e == nil || e.spread == 0 {
+		return 0
+	}
+	lowest := e.lowest
+	if first.DuelCount == lowest && !e.lowestShared && !e.behind[first.ID] {
+		/* first is about to leave the lowest spot */
+		lowest = lowest + 1
+	}
+	return lowest + e.spread
+}
+
+func (e *Exclusions) held(id uint) bool {
+	return e.holdBack && e.behind[id]
+}
+
+/* noPartner marks an artwork for which no partner was allowed */
+func (e *Exclusions) noPartner(id uint) {
+	if e != nil {
+		e.skip[id] = true
+	}
+}
+
+/* separateArtists adds the rule that works by the same artist must not
+   meet. Artists are compared ignoring case and spacing. */
+func (e *Exclusions) separateArtists(all []*database.Artwork) {
+	e.artists = map[uint]string{}
+	for _, a := range all {
+		e.artists[a.ID] = normalizeArtist(a.Artist)
+	}
+}
+
+func normalizeArtist(artist string) string {
+	return strings.ToLower(strings.Join(strings.Fields(artist), " "))
+}
+
+/* balance adds the rules that spread the duels evenly: no artwork gets
+   more than spread duels ahead of the one with the fewest duels. Artworks
+   more than spread duels behind the one with the most, usually ones
+   added late, are catching up and don't count for that. They get as
+   many duels as they can, but only in share of all duels, so the others
+   aren't starved meanwhile. */
+func (e *Exclusions) balance(all []*database.Artwork, spread int, share float64, rng *rand.Rand) {
+	var in []*database.Artwork
+	var most uint64
+	for _, a := range all {
+		if e.category == "" || a.Category == e.category {
+			in = append(in, a)
+			most = max(most, a.DuelCount)
+		}
+	}
+	if len(in) == 0 || spread <= 0 {
+		return
+	}
+
+	e.spread = uint64(spread)
+	e.behind = map[uint]bool{}
+	first := true
+	for _, a := range in {
+		if catchingUp(a, most, e.spread) {
+			e.behind[a.ID] = true
+		} else if first || a.DuelCount < e.lowest {
+			e.lowest = a.DuelCount
+			e.lowestShared = false
+			first = false
+		} else if a.DuelCount == e.lowest {
+			e.lowestShared = true
+		}
+	}
+	e.holdBack = len(e.behind) > 0 && rng.Float64() >= share
+}
+
+/* relaxed returns the exclusions for the next attempt when nothing could
+   be paired: first artworks that are catching up may take part again,
+   then the artwork cooldown is dropped, then the pair cooldowns, the
+   duel spread and the artists rule. The category always stays. nil
+   means there is nothing left to drop. */
+func (e *Exclusions) relaxed() *Exclusions {
+	if e == nil {
+		return nil
+	}
+	r := *e
+	r.skip = map[uint]bool{}
+	if e.holdBack {
+		r.holdBack = false
+	} else if e.artworkCooldown > 0 {
+		r.artworkCooldown = 0
+	} else if e.history != nil {
+		r.history = nil
+	} else if e.spread > 0 {
+		r.spread = 0
+	} else if e.artists != nil {
+		r.artists = nil
+	} else {
+		return nil
+	}
+	return &r
+}
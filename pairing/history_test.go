@@ -0,0 +1,284 @@
+package pairing
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+/* an artwork sits out artwork_cooldown duels after being shown */
+func TestArtworkCooldown(t *testing.T) {
+	p := withPairing(t)
+	p.ArtworkCooldown = 2
+
+	h := NewHistory()
+	h.Add(1, 2)
+	one := &database.Artwork{}
+	one.ID = 1
+	for i, want := range []bool{true, true, false} {
+		ex := h.Exclusions("")
+		if got := ex.Artwork(one); got != want {
+			t.Errorf("after %d more duels, got excluded %t, want %t", i, got, want)
+		}
+		if got := slices.Contains(ex.Artworks(), 1); got != want {
+			t.Errorf("after %d more duels, got listed %t, want %t", i, got, want)
+		}
+		h.Add(3, 4)
+	}
+	if got := h.Exclusions("").Artworks(); !slices.Equal(got, []uint{3, 4}) {
+		t.Errorf("got %v excluded, want [3 4]", got)
+	}
+}
+
+/* two artworks meet again after pair_cooldown duels */
+func TestPairCooldown(t *testing.T) {
+	p := withPairing(t)
+	p.PairCooldown = 2
+
+	h := NewHistory()
+	h.Add(2, 1)
+	for i, want := range []bool{true, true, false} {
+		ex := h.Exclusions("")
+		if got := ex.Pair(1, 2); got != want {
+			t.Errorf("after %d more duels, got excluded %t, want %t", i, got, want)
+		}
+		if got := ex.Pair(2, 1); got != want {
+			t.Errorf("after %d more duels, the other way round got excluded %t, want %t", i, got, want)
+		}
+		if got := slices.Contains(ex.Partners(1), 2); got != want {
+			t.Errorf("after %d more duels, got listed %t, want %t", i, got, want)
+		}
+		if ex.Pair(1, 3) || slices.Contains(ex.Partners(1), 3) {
+			t.Errorf("after %d more duels, 1 and 3 are excluded without having met", i)
+		}
+		h.Add(3, 4)
+	}
+}
+
+func TestNoRematches(t *testing.T) {
+	p := withPairing(t)
+	p.NoRematches = true
+
+	h := NewHistory()
+	h.Add(1, 2)
+	for i := 0; i < 50; i++ {
+		h.Add(3, 4)
+	}
+	ex := h.Exclusions("")
+	if !ex.Pair(1, 2) || !ex.Pair(3, 4) {
+		t.Error("pairs that met may meet again")
+	}
+	if ex.Pair(1, 3) {
+		t.Error("pair that didn't meet may not meet")
+	}
+	if got := ex.Partners(1); !slices.Equal(got, []uint{2}) {
+		t.Errorf("got partners %v excluded, want [2]", got)
+	}
+}
+
+/* relaxing drops the artwork cooldown first, then the pair rules */
+func TestHistoryRelaxed(t *testing.T) {
+	p := withPairing(t)
+	p.ArtworkCooldown = 5
+	p.PairCooldown = 5
+	p.NoRematches = true
+
+	h := NewHistory()
+	h.Add(1, 2)
+	one := &database.Artwork{}
+	one.ID = 1
+	ex := h.Exclusions("")
+	if !ex.Artwork(one) || !ex.Pair(1, 2) {
+		t.Fatal("history doesn't apply")
+	}
+	ex = ex.relaxed()
+	if ex.Artwork(one) || len(ex.Artworks()) != 0 {
+		t.Errorf("artwork cooldown still applies, excluding %v", ex.Artworks())
+	}
+	if !ex.Pair(1, 2) {
+		t.Error("pair rules dropped with the artwork cooldown")
+	}
+	ex = ex.relaxed()
+	if ex.Pair(1, 2) || len(ex.Partners(1)) != 0 {
+		t.Errorf("pair rules still apply, excluding %v", ex.Partners(1))
+	}
+	if ex.relaxed() != nil {
+		t.Error("got more to relax")
+	}
+	if !h.Exclusions("").Pair(1, 2) {
+		t.Error("relaxing changed the history")
+	}
+}
+
+/* seeding with the stored duels gives the same as adding them live */
+func TestSeed(t *testing.T) {
+	p := withPairing(t)
+	p.ArtworkCooldown = 2
+	p.PairCooldown = 3
+
+	seeded := NewHistory()
+	seeded.Seed([]*database.Duel{
+		{Duelist1: 1, Duelist2: 2},
+		{Duelist1: 3, Duelist2: 1},
+		{Duelist1: 4, Duelist2: 5},
+	})
+	added := NewHistory()
+	added.Add(1, 2)
+	added.Add(3, 1)
+	added.Add(4, 5)
+	for _, h := range []*History{seeded, added} {
+		ex := h.Exclusions("")
+		if got := ex.Artworks(); !slices.Equal(got, []uint{1, 3, 4, 5}) {
+			t.Errorf("got %v excluded, want [1 3 4 5]", got)
+		}
+		if !ex.Pair(1, 2) || !ex.Pair(1, 3) || ex.Pair(2, 3) {
+			t.Errorf("got pairs 1-2 %t, 1-3 %t, 2-3 %t, want true, true, false",
+				ex.Pair(1, 2), ex.Pair(1, 3), ex.Pair(2, 3))
+		}
+	}
+	seeded.Add(6, 7)
+	if seeded.Exclusions("").Pair(1, 2) {
+		t.Error("pair 1-2 is still cooling down after three duels")
+	}
+	empty := NewHistory()
+	empty.Seed(nil)
+	if len(empty.Exclusions("").Artworks()) != 0 {
+		t.Error("empty history excludes artworks")
+	}
+}
+
+/* duels added to a clone don't show in the original */
+func TestClone(t *testing.T) {
+	p := withPairing(t)
+	p.NoRematches = true
+
+	h := NewHistory()
+	h.Add(1, 2)
+	c := h.Clone()
+	c.Add(3, 4)
+	if !c.Exclusions("").Pair(1, 2) || !c.Exclusions("").Pair(3, 4) {
+		t.Error("clone lost duels")
+	}
+	if h.Exclusions("").Pair(3, 4) {
+		t.Error("duel added to the clone shows in the original")
+	}
+}
+
+/* artworks outside of the category never take part, however relaxed */
+func TestExclusionsCategory(t *testing.T) {
+	withPairing(t)
+	digital := &database.Artwork{Category: "digital"}
+	other := &database.Artwork{Category: "traditional"}
+	for ex := NewHistory().Exclusions("digital"); ex != nil; ex = ex.relaxed() {
+		if ex.Artwork(digital) || !ex.Artwork(other) {
+			t.Errorf("got digital excluded %t, traditional %t, want false and true",
+				ex.Artwork(digital), ex.Artwork(other))
+		}
+	}
+	if NewHistory().Exclusions("").Artwork(other) {
+		t.Error("without a category, an artwork is excluded")
+	}
+}
+
+func TestNormalizeArtist(t *testing.T) {
+	for _, tc := range []struct {
+		artist	string
+		want	string
+	}{
+		{"Kim", "kim"},
+		{"  Kim   Fuchs ", "kim fuchs"},
+		{"KIM\tFUCHS", "kim fuchs"},
+		{"Zoë Fuchs", "zoë fuchs"},
+		{" ", ""},
+	} {
+		if got := normalizeArtist(tc.artist); got != tc.want {
+			t.Errorf("got %q for %q, want %q", got, tc.artist, tc.want)
+		}
+	}
+}
+
+func TestSeparateArtists(t *testing.T) {
+	var all []*database.Artwork
+	for i, artist := range []string{"Kim Fuchs", "kim  fuchs", "Alex", "", ""} {
+		a := &database.Artwork{Artist: artist}
+		a.ID = uint(i + 1)
+		all = append(all, a)
+	}
+	ex := NewHistory().Exclusions("")
+	ex.separateArtists(all)
+	for _, tc := range []struct {
+		a, b	uint
+		want	bool
+	}{
+		{1, 2, true},
+		{2, 1, true},
+		{1, 3, false},
+		/* nobody knows who made these */
+		{4, 5, false},
+		{3, 4, false},
+	} {
+		if got := ex.Pair(tc.a, tc.b); got != tc.want {
+			t.Errorf("got %d-%d excluded %t, want %t", tc.a, tc.b, got, tc.want)
+		}
+	}
+	if got := ex.Partners(1); !slices.Equal(got, []uint{2}) {
+		t.Errorf("got partners %v excluded, want [2]", got)
+	}
+	if got := ex.Partners(4); len(got) != 0 {
+		t.Errorf("got partners %v excluded, want none", got)
+	}
+}
+
+/* works by the same artist never meet while there are others */
+func TestPairSeparatesArtists(t *testing.T) {
+	p := withPairing(t)
+	p.SeparateArtists = true
+
+	repo := database.NewMemoryRepository()
+	a := add(t, repo,
+		&database.Artwork{Artist: "Kim Fuchs", EloRating: 1000},
+		&database.Artwork{Artist: "KIM FUCHS ", EloRating: 1000},
+		&database.Artwork{Artist: "Alex", EloRating: 1200},
+	)
+	rng := rand.New(rand.NewSource(1))
+	for _, s := range []Strategy{&Similar{Contenders: 5}, &Weighted{Spread: 100}, &Swiss{}, &Information{}} {
+		t.Run(s.Name(), func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				a1, a2, err := Pair(s, repo, rng, NewHistory().Exclusions(""))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a1.ID != a[2].ID && a2.ID != a[2].ID {
+					t.Fatalf("got %d and %d by the same artist", a1.ID, a2.ID)
+				}
+			}
+		})
+	}
+}
+
+/* with only one artist left in the category, their works meet anyway */
+func TestPairOneArtistLeft(t *testing.T) {
+	p := withPairing(t)
+	p.SeparateArtists = true
+
+	repo := database.NewMemoryRepository()
+	add(t, repo,
+		&database.Artwork{Artist: "Kim Fuchs", Category: "digital", EloRating: 1000},
+		&database.Artwork{Artist: "kim fuchs", Category: "digital", EloRating: 1000},
+		&database.Artwork{Artist: "Alex", Category: "traditional", EloRating: 1000},
+	)
+	rng := rand.New(rand.NewSource(1))
+	for _, s := range []Strategy{&Similar{Contenders: 5}, &Weighted{Spread: 100}, &Swiss{}, &Information{}} {
+		t.Run(s.Name(), func(t *testing.T) {
+			a1, a2, err := Pair(s, repo, rng, NewHistory().Exclusions("digital"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a1.Category != "digital" || a2.Category != "digital" || a1.ID == a2.ID {
+				t.Errorf("got %d in %q and %d in %q", a1.ID, a1.Category, a2.ID, a2.Category)
+			}
+		})
+	}
+}
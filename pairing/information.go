@@ -0,0 +1,91 @@
+package pairing
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* Information picks the pair whose result tells us the most about the
+   ranking: the outcome should be as open as possible, and the ratings
+   involved as uncertain as possible. A pair scores
+       p * (1 - p) * (var1 + var2)
+   where p is the chance that one wins, and var the variance of a
+   rating. With Glicko-2 that is the squared rating deviation, with Elo
+   it is estimated from the number of duels. */
+type Information struct {
+}
+
+func (s *Information) Name() string {
+	return "information"
+}
+
+func (s *Information) Pair(db Repository, rng *rand.Rand, ex *Exclusions) (*database.Artwork, *database.Artwork, error) {
+	all, err := db.GetAllArtworks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error with contender list: %s\n", err)
+		return nil, nil, err
+	}
+
+	variances := make([]float64, len(all))
+	for i, a := range all {
+		variances[i] = variance(a)
+	}
+	var best [][2]int
+	bestScore := -1.0
+	for i := 0; i < len(all); i++ {
+		if ex.Artwork(all[i]) {
+			continue
+		}
+		for j := i + 1; j < len(all); j++ {
+			if ex.Artwork(all[j]) || ex.Pair(all[i].ID, all[j].ID) {
+				continue
+			}
+			if !withinSpread(ex, all[i], all[j]) {
+				continue
+			}
+			p := 1.0 / (1.0 + math.Pow(10, (all[j].EloRating - all[i].EloRating)/400.0))
+			score := p * (1 - p) * (variances[i] + variances[j])
+			if score > bestScore {
+				bestScore = score
+				best = [][2]int{{i, j}}
+			} else if score == bestScore {
+				best = append(best, [2]int{i, j})
+			}
+		}
+	}
+	if len(best) == 0 {
+		return nil, nil, ErrNoContenders
+	}
+	pick := best[rng.Intn(len(best))]
+	/* the one with fewer duels on the left, like the other strategies */
+	a1, a2 := all[pick[0]], all[pick[1]]
+	if a2.DuelCount < a1.DuelCount {
+		a1, a2 = a2, a1
+	}
+	return a1, a2, nil
+}
+
+/* withinSpread tells whether a duel between a and b keeps the spread of
+   duel counts within limits */
+func withinSpread(ex *Exclusions, a, b *database.Artwork) bool {
+	if b.DuelCount < a.DuelCount {
+		a, b = b, a
+	}
+	below := ex.DuelsBelow(a)
+	return below == 0 || b.DuelCount < below
+}
+
+func variance(a *database.Artwork) float64 {
+	if config.RatingAlgorithm() == "glicko2" {
+		return a.RatingDeviation * a.RatingDeviation
+	}
+	/* the uncertainty of an Elo rating roughly halves with every
+	   fourfold increase in duels */
+	d := config.RatingGlicko2InitialDeviation()
+	return d * d / float64(1 + a.DuelCount)
+}
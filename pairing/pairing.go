@@ -0,0 +1,96 @@
+/* Package pairing decides which two artworks face each other next. */
+package pairing
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* Repository is everything the strategies need from the database. */
+type Repository interface {
+	GetArtworksWithLowestDuelCount(f database.Filter) ([]*database.Artwork, error)
+	GetArtworksWithSimilarEloRating(benchmark *database.Artwork, count int, f database.Filter) ([]*database.Artwork, error)
+	GetAllArtworks() ([]*database.Artwork, error)
+	GetDuelsOfArtwork(id uint) ([]*database.Duel, error)
+}
+
+/* Strategy picks the next duel, avoiding everything in ex. ex may be nil.
+   All randomness comes from rng, so simulations can be reproduced. */
+type Strategy interface {
+	Name() string
+	Pair(db Repository, rng *rand.Rand, ex *Exclusions) (*database.Artwork, *database.Artwork, error)
+}
+
+var ErrNoContenders = errors.New("no contenders available")
+
+/* errNoPartner means the first artwork picked had nobody to meet, the
+   strategy has marked it in the exclusions. */
+var errNoPartner = errors.New("no partner available")
+
+/* Pair runs a strategy. Artworks that find no partner are passed over,
+   and if nothing can be paired at all, the exclusions are relaxed step
+   by step, so there is always a duel if there are two artworks in the
+   category. */
+func Pair(s Strategy, db Repository, rng *rand.Rand, ex *Exclusions) (*database.Artwork, *database.Artwork, error) {
+	if ex != nil && (config.PairingSeparateArtists() || config.PairingMaxSpread() > 0) {
+		all, err := db.GetAllArtworks()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading artworks: %s\n", err)
+			return nil, nil, err
+		}
+		if config.PairingSeparateArtists() {
+			ex.separateArtists(all)
+		}
+		ex.balance(all, config.PairingMaxSpread(), config.PairingCatchUpShare(), rng)
+	}
+	for {
+		a1, a2, err := s.Pair(db, rng, ex)
+		if errors.Is(err, errNoPartner) && ex != nil {
+			continue
+		}
+		if !errors.Is(err, errNoPartner) && !errors.Is(err, ErrNoContenders) {
+			return a1, a2, err
+		}
+		ex = ex.relaxed()
+		if ex == nil {
+			return nil, nil, ErrNoContenders
+		}
+	}
+}
+
+/* firstArtwork returns the allowed artwork with the fewest duels, a
+   random one if there are several. */
+func firstArtwork(db Repository, rng *rand.Rand, ex *Exclusions) (*database.Artwork, error) {
+	lowest, err := db.GetArtworksWithLowestDuelCount(database.Filter{
+		Category: ex.Category(),
+		Exclude: ex.Artworks(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading artwork: %s\n", err)
+		return nil, err
+	}
+	if len(lowest) == 0 {
+		return nil, ErrNoContenders
+	}
+	return lowest[rng.Intn(len(lowest))], nil
+}
+
+/* FromConfiguration returns the strategy selected by pairing.strategy,
+   with its current settings. */
+func FromConfiguration() Strategy {
+	switch config.PairingStrategy() {
+	case "weighted":
+		return &Weighted{Spread: config.PairingSpread()}
+	case "swiss":
+		return &Swiss{}
+	case "information":
+		return &Information{}
+	default:
+		return &Similar{Contenders: config.PairingContenders()}
+	}
+}
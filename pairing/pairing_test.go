@@ -0,0 +1,444 @@
+package pairing
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinx/proto-artbattle/database"
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+const testConfiguration = `
+server:
+  port: 5000
+database:
+  driver: "memory"
+serial_port:
+  baud_rate: 9600
+input:
+  sources: ["stdin"]
+images:
+  path: "images/"
+`
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "pairing")
+	if err != nil {
+		panic(err)
+	}
+	file := filepath.Join(dir, "artbattle.conf.yaml")
+	err = os.WriteFile(file, []byte(testConfiguration), 0644)
+	if err == nil {
+		flag.Set("config", file)
+		err = config.StartupLoadConfiguration()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+/* withPairing restores the pairing settings after the test, so it may
+   change them. The duel spread starts out unlimited. */
+func withPairing(t *testing.T) *config.PairingConfig {
+	c := config.Configuration()
+	saved := c.Pairing
+	spread := 0
+	c.Pairing.MaxSpread = &spread
+	t.Cleanup(func() {
+		c.Pairing = saved
+	})
+	return &c.Pairing
+}
+
+/* artwork returns an artwork to add, by an artist of its own */
+func artwork(rating float64, duels uint64) *database.Artwork {
+	return &database.Artwork{EloRating: rating, DuelCount: duels}
+}
+
+/* add stores the artworks, numbered on from those in the repository */
+func add(t *testing.T, repo database.Repository, artworks ...*database.Artwork) []*database.Artwork {
+	all, err := repo.GetAllArtworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range artworks {
+		n := len(all) + i + 1
+		a.Title = fmt.Sprintf("Artwork %d", n)
+		if a.Artist == "" {
+			a.Artist = fmt.Sprintf("Artist %d", n)
+		}
+		a.Panel = fmt.Sprintf("A%d", n)
+		a.Filename = fmt.Sprintf("artwork%d.jpg", n)
+		err = repo.AddArtwork(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return artworks
+}
+
+/* pairs runs a strategy n times on the same repository and counts the
+   partners of the first artwork by id */
+func pairs(t *testing.T, s Strategy, repo Repository, n int, first uint) map[uint]int {
+	rng := rand.New(rand.NewSource(1))
+	res := map[uint]int{}
+	for i := 0; i < n; i++ {
+		a1, a2, err := s.Pair(repo, rng, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a1.ID != first {
+			t.Fatalf("got artwork %d first, want %d", a1.ID, first)
+		}
+		res[a2.ID] = res[a2.ID] + 1
+	}
+	return res
+}
+
+func TestSimilar(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	a := add(t, repo, artwork(1000, 0), artwork(700, 1), artwork(990, 1), artwork(1020, 1), artwork(1300, 1))
+	/* the next one above and below */
+	got := pairs(t, &Similar{Contenders: 2}, repo, 100, a[0].ID)
+	if len(got) != 2 || got[a[2].ID] == 0 || got[a[3].ID] == 0 {
+		t.Errorf("got partners %v, want %d and %d", got, a[2].ID, a[3].ID)
+	}
+	/* two above and the next one below */
+	got = pairs(t, &Similar{Contenders: 3}, repo, 100, a[0].ID)
+	if len(got) != 3 || got[a[1].ID] != 0 {
+		t.Errorf("got partners %v, want all but %d", got, a[1].ID)
+	}
+}
+
+/* artworks with the fewest duels take turns going first */
+func TestSimilarFirstArtwork(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	a := add(t, repo, artwork(1000, 2), artwork(1000, 1), artwork(1000, 1), artwork(1000, 3))
+	rng := rand.New(rand.NewSource(1))
+	firsts := map[uint]int{}
+	for i := 0; i < 100; i++ {
+		a1, _, err := (&Similar{Contenders: 3}).Pair(repo, rng, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		firsts[a1.ID] = firsts[a1.ID] + 1
+	}
+	if len(firsts) != 2 || firsts[a[1].ID] == 0 || firsts[a[2].ID] == 0 {
+		t.Errorf("got first artworks %v, want %d and %d", firsts, a[1].ID, a[2].ID)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	a := add(t, repo, artwork(1000, 0), artwork(1000, 1), artwork(1100, 1), artwork(1400, 1))
+	got := pairs(t, &Weighted{Spread: 100}, repo, 3000, a[0].ID)
+	/* weights 1, 1/2 and 1/65536 */
+	if got[a[1].ID] < 1800 || got[a[1].ID] > 2200 {
+		t.Errorf("got the same rating %d times out of 3000, want about 2000", got[a[1].ID])
+	}
+	if got[a[2].ID] < 800 || got[a[2].ID] > 1200 {
+		t.Errorf("got spread points away %d times out of 3000, want about 1000", got[a[2].ID])
+	}
+	if got[a[3].ID] > 2 {
+		t.Errorf("got 4 spreads away %d times out of 3000, want hardly ever", got[a[3].ID])
+	}
+}
+
+/* when all weights round to 0, any partner will do */
+func TestWeightedFarAway(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	a := add(t, repo, artwork(1000, 0), artwork(9000, 1), artwork(9500, 1))
+	got := pairs(t, &Weighted{Spread: 100}, repo, 100, a[0].ID)
+	if got[a[1].ID] == 0 || got[a[2].ID] == 0 {
+		t.Errorf("got partners %v, want both", got)
+	}
+}
+
+/* the partner is one not met yet, in the same round if possible, with
+   the closest rating */
+func TestSwiss(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	a := add(t, repo,
+		artwork(1000, 1),
+		/* met already */
+		artwork(1000, 2),
+		/* two rounds ahead */
+		artwork(1000, 3),
+		/* next round */
+		artwork(1200, 2),
+		artwork(1050, 2),
+		/* met, but the duel timed out */
+		artwork(960, 2),
+	)
+	for _, d := range []*database.Duel{
+		{Duelist1: a[0].ID, Duelist2: a[1].ID, Winner: a[1].ID},
+		{Duelist1: a[5].ID, Duelist2: a[0].ID, TimedOut: true},
+	} {
+		err := repo.AddDuel(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := pairs(t, &Swiss{}, repo, 20, a[0].ID)
+	if got[a[5].ID] != 20 {
+		t.Errorf("got partners %v, want %d", got, a[5].ID)
+	}
+
+	/* once everybody was met, the round counts first */
+	for _, d := range []*database.Duel{
+		{Duelist1: a[0].ID, Duelist2: a[2].ID, Winner: a[0].ID},
+		{Duelist1: a[0].ID, Duelist2: a[3].ID, Winner: a[0].ID},
+		{Duelist1: a[0].ID, Duelist2: a[4].ID, Winner: a[0].ID},
+		{Duelist1: a[0].ID, Duelist2: a[5].ID, Winner: a[0].ID},
+	} {
+		err := repo.AddDuel(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got = pairs(t, &Swiss{}, repo, 20, a[0].ID)
+	if got[a[1].ID] != 20 {
+		t.Errorf("got partners %v, want %d", got, a[1].ID)
+	}
+}
+
+/* equally good partners take turns */
+func TestSwissTies(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	a := add(t, repo, artwork(1000, 0), artwork(1050, 0), artwork(950, 0))
+	rng := rand.New(rand.NewSource(1))
+	seen := map[uint]int{}
+	for i := 0; i < 50; i++ {
+		a1, a2, err := (&Swiss{}).Pair(repo, rng, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[a1.ID] = seen[a1.ID] + 1
+		seen[a2.ID] = seen[a2.ID] + 1
+	}
+	if len(seen) != len(a) || seen[a[0].ID] == 0 {
+		t.Errorf("got artworks %v, want all", seen)
+	}
+}
+
+func TestInformation(t *testing.T) {
+	repo := database.NewMemoryRepository()
+	a := add(t, repo,
+		artwork(1000, 2),
+		artwork(1000, 1),
+		/* certain */
+		artwork(1000, 10),
+		/* uncertain, but far off */
+		artwork(1800, 0),
+	)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		a1, a2, err := (&Information{}).Pair(repo, rng, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		/* the one with fewer duels first */
+		if a1.ID != a[1].ID || a2.ID != a[0].ID {
+			t.Fatalf("got %d and %d, want %d and %d", a1.ID, a2.ID, a[1].ID, a[0].ID)
+		}
+	}
+}
+
+/* with Glicko-2, the rating deviation tells the uncertainty, ties are
+   broken at random */
+func TestInformationGlicko2(t *testing.T) {
+	c := config.Configuration()
+	saved := c.Rating.Algorithm
+	c.Rating.Algorithm = "glicko2"
+	t.Cleanup(func() {
+		c.Rating.Algorithm = saved
+	})
+
+	repo := database.NewMemoryRepository()
+	a := add(t, repo, artwork(1000, 5), artwork(1000, 5), artwork(1000, 5))
+	a[0].RatingDeviation = 300
+	a[1].RatingDeviation = 50
+	a[2].RatingDeviation = 50
+	for _, artwork := range a {
+		err := repo.UpdateArtwork(artwork)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	partners := map[uint]int{}
+	for i := 0; i < 50; i++ {
+		a1, a2, err := (&Information{}).Pair(repo, rng, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a1.ID == a[0].ID {
+			partners[a2.ID] = partners[a2.ID] + 1
+		} else if a2.ID == a[0].ID {
+			partners[a1.ID] = partners[a1.ID] + 1
+		} else {
+			t.Fatalf("got %d and %d, want %d in the duel", a1.ID, a2.ID, a[0].ID)
+		}
+	}
+	if partners[a[1].ID] == 0 || partners[a[2].ID] == 0 {
+		t.Errorf("got partners %v, want both", partners)
+	}
+}
+
+/* the relaxation steps come in order, one at a time, and end with nil */
+func TestRelaxed(t *testing.T) {
+	h := NewHistory()
+	e := &Exclusions{
+		category: "digital",
+		history: h,
+		artworkCooldown: 2,
+		pairCooldown: 3,
+		artists: map[uint]string{1: "kim"},
+		skip: map[uint]bool{1: true},
+		spread: 3,
+		behind: map[uint]bool{2: true},
+		holdBack: true,
+	}
+	steps := []struct {
+		name	string
+		dropped	func(e *Exclusions) bool
+	}{
+		{"hold back", func(e *Exclusions) bool { return !e.holdBack }},
+		{"artwork cooldown", func(e *Exclusions) bool { return e.artworkCooldown == 0 }},
+		{"history", func(e *Exclusions) bool { return e.history == nil }},
+		{"spread", func(e *Exclusions) bool { return e.spread == 0 }},
+		{"artists", func(e *Exclusions) bool { return e.artists == nil }},
+	}
+	for i, s := range steps {
+		r := e.relaxed()
+		if r == nil {
+			t.Fatalf("got nil before dropping %s", s.name)
+		}
+		for j, other := range steps {
+			if other.dropped(r) != (j <= i) {
+				t.Errorf("after dropping %s, %s dropped: %t", s.name, other.name, other.dropped(r))
+			}
+		}
+		if len(r.skip) != 0 {
+			t.Errorf("after dropping %s, still skipping %v", s.name, r.skip)
+		}
+		if r.Category() != "digital" {
+			t.Errorf("after dropping %s, got category %q", s.name, r.Category())
+		}
+		e = r
+	}
+	if r := e.relaxed(); r != nil {
+		t.Errorf("got %+v after dropping everything, want nil", r)
+	}
+	if (*Exclusions)(nil).relaxed() != nil {
+		t.Error("relaxing nil didn't give nil")
+	}
+}
+
+/* with two artworks in the category, there is always a duel, however
+   much stands in the way */
+func TestPairRelaxes(t *testing.T) {
+	p := withPairing(t)
+	p.ArtworkCooldown = 5
+	p.PairCooldown = 5
+	p.NoRematches = true
+	p.SeparateArtists = true
+	spread := 1
+	p.MaxSpread = &spread
+
+	repo := database.NewMemoryRepository()
+	a := add(t, repo,
+		&database.Artwork{Artist: "Kim", Category: "digital", EloRating: 1000, DuelCount: 4},
+		&database.Artwork{Artist: "kim ", Category: "digital", EloRating: 1000, DuelCount: 1},
+		&database.Artwork{Artist: "Alex", Category: "traditional", EloRating: 1000, DuelCount: 0},
+	)
+	h := NewHistory()
+	h.Add(a[0].ID, a[1].ID)
+	rng := rand.New(rand.NewSource(1))
+	for _, s := range []Strategy{&Similar{Contenders: 5}, &Weighted{Spread: 100}, &Swiss{}, &Information{}} {
+		t.Run(s.Name(), func(t *testing.T) {
+			a1, a2, err := Pair(s, repo, rng, h.Exclusions("digital"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a1.ID != a[1].ID || a2.ID != a[0].ID {
+				t.Errorf("got %d and %d, want %d and %d", a1.ID, a2.ID, a[1].ID, a[0].ID)
+			}
+		})
+	}
+}
+
+/* an artwork without a partner is passed over before anything is
+   relaxed */
+func TestPairPassesOver(t *testing.T) {
+	p := withPairing(t)
+	p.NoRematches = true
+
+	repo := database.NewMemoryRepository()
+	a := add(t, repo, artwork(1000, 0), artwork(1000, 1), artwork(1000, 1))
+	h := NewHistory()
+	h.Add(a[0].ID, a[1].ID)
+	h.Add(a[0].ID, a[2].ID)
+	rng := rand.New(rand.NewSource(1))
+	for _, s := range []Strategy{&Similar{Contenders: 5}, &Weighted{Spread: 100}, &Swiss{}} {
+		t.Run(s.Name(), func(t *testing.T) {
+			ex := h.Exclusions("")
+			a1, a2, err := Pair(s, repo, rng, ex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a1.ID == a[0].ID || a2.ID == a[0].ID {
+				t.Errorf("got %d and %d, want %d left out", a1.ID, a2.ID, a[0].ID)
+			}
+			if !ex.skip[a[0].ID] {
+				t.Errorf("%d wasn't marked as without a partner", a[0].ID)
+			}
+		})
+	}
+}
+
+/* with fewer than two artworks in the category, relaxing ends */
+func TestPairNoContenders(t *testing.T) {
+	p := withPairing(t)
+	p.ArtworkCooldown = 5
+	p.NoRematches = true
+	p.SeparateArtists = true
+	spread := 1
+	p.MaxSpread = &spread
+
+	repo := database.NewMemoryRepository()
+	add(t, repo,
+		&database.Artwork{Category: "digital", EloRating: 1000},
+		&database.Artwork{Category: "traditional", EloRating: 1000},
+	)
+	h := NewHistory()
+	rng := rand.New(rand.NewSource(1))
+	for _, s := range []Strategy{&Similar{Contenders: 5}, &Weighted{Spread: 100}, &Swiss{}, &Information{}} {
+		t.Run(s.Name(), func(t *testing.T) {
+			_, _, err := Pair(s, repo, rng, h.Exclusions("digital"))
+			if !errors.Is(err, ErrNoContenders) {
+				t.Errorf("got error %v, want %v", err, ErrNoContenders)
+			}
+		})
+	}
+
+	repo = database.NewMemoryRepository()
+	add(t, repo, artwork(1000, 0))
+	for _, s := range []Strategy{&Similar{Contenders: 5}, &Weighted{Spread: 100}, &Swiss{}, &Information{}} {
+		t.Run(s.Name() + " without exclusions", func(t *testing.T) {
+			_, _, err := Pair(s, repo, rng, nil)
+			if !errors.Is(err, ErrNoContenders) {
+				t.Errorf("got error %v, want %v", err, ErrNoContenders)
+			}
+		})
+	}
+}
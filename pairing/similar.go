@@ -0,0 +1,50 @@
+package pairing
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+/* Similar pairs the artwork with the fewest duels with a random one of
+   the Contenders closest to its rating. */
+type Similar struct {
+	Contenders	int
+}
+
+func (s *Similar) Name() string {
+	return "similar"
+}
+
+func (s *Similar) Pair(db Repository, rng *rand.Rand, ex *Exclusions) (*database.Artwork, *database.Artwork, error) {
+	a1, err := firstArtwork(db, rng, ex)
+	if err != nil {
+		return nil, nil, err
+	}
+	a2, err := s.getDuelPartner(db, a1, rng, ex)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a1, a2, nil
+}
+
+func (s *Similar) getDuelPartner(db Repository, a *database.Artwork, rng *rand.Rand, ex *Exclusions) (*database.Artwork, error) {
+	/* get possible contenders with similar Elo rating */
+	artworks, err := db.GetArtworksWithSimilarEloRating(a, s.Contenders, database.Filter{
+		Category: ex.Category(),
+		Exclude: ex.Partners(a.ID),
+		DuelsBelow: ex.DuelsBelow(a),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error with contender list: %s\n", err)
+		return nil, err
+	}
+	if len(artworks) < 1 {
+		ex.noPartner(a.ID)
+		return nil, errNoPartner
+	}
+	/* version 1: just return a random element */
+	return artworks[rng.Intn(len(artworks))], nil
+}
@@ -0,0 +1,69 @@
+package pairing
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+/* Swiss pairs like a Swiss-system tournament: the artwork with the fewest
+   duels meets one that has played as many duels ("the same round") and
+   has the closest rating, and never the same opponent twice as long as
+   there are others left. */
+type Swiss struct {
+}
+
+func (s *Swiss) Name() string {
+	return "swiss"
+}
+
+func (s *Swiss) Pair(db Repository, rng *rand.Rand, ex *Exclusions) (*database.Artwork, *database.Artwork, error) {
+	a1, err := firstArtwork(db, rng, ex)
+	if err != nil {
+		return nil, nil, err
+	}
+	contenders, err := getContenders(db, a1, ex)
+	if err != nil {
+		return nil, nil, err
+	}
+	duels, err := db.GetDuelsOfArtwork(a1.ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading duels: %s\n", err)
+		return nil, nil, err
+	}
+	met := map[uint]bool{}
+	for _, d := range duels {
+		/* without a vote, they haven't really met */
+		if d.TimedOut {
+			continue
+		}
+		met[d.Duelist1] = true
+		met[d.Duelist2] = true
+	}
+
+	/* shuffle first, so equally good contenders take turns */
+	rng.Shuffle(len(contenders), func(i, j int) {
+		contenders[i], contenders[j] = contenders[j], contenders[i]
+	})
+	round := func(c *database.Artwork) uint64 {
+		if c.DuelCount > a1.DuelCount {
+			return c.DuelCount - a1.DuelCount
+		}
+		return a1.DuelCount - c.DuelCount
+	}
+	sort.SliceStable(contenders, func(i, j int) bool {
+		ci, cj := contenders[i], contenders[j]
+		if met[ci.ID] != met[cj.ID] {
+			return !met[ci.ID]
+		}
+		if round(ci) != round(cj) {
+			return round(ci) < round(cj)
+		}
+		return math.Abs(ci.EloRating - a1.EloRating) < math.Abs(cj.EloRating - a1.EloRating)
+	})
+	return a1, contenders[0], nil
+}
@@ -0,0 +1,76 @@
+package pairing
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/tinx/proto-artbattle/database"
+)
+
+/* Weighted pairs the artwork with the fewest duels with any other one,
+   but the further apart their ratings are, the less likely. An artwork
+   Spread rating points away is picked half as often as one with the same
+   rating, 2*Spread away a sixteenth as often. */
+type Weighted struct {
+	Spread	float64
+}
+
+func (w *Weighted) Name() string {
+	return "weighted"
+}
+
+func (w *Weighted) Pair(db Repository, rng *rand.Rand, ex *Exclusions) (*database.Artwork, *database.Artwork, error) {
+	a1, err := firstArtwork(db, rng, ex)
+	if err != nil {
+		return nil, nil, err
+	}
+	contenders, err := getContenders(db, a1, ex)
+	if err != nil {
+		return nil, nil, err
+	}
+	weights := make([]float64, len(contenders))
+	total := 0.0
+	for i, c := range contenders {
+		d := (c.EloRating - a1.EloRating) / w.Spread
+		weights[i] = math.Exp2(-d * d)
+		total = total + weights[i]
+	}
+	if total == 0 {
+		/* everybody is far, far away */
+		return a1, contenders[rng.Intn(len(contenders))], nil
+	}
+	x := rng.Float64() * total
+	for i, c := range contenders {
+		x = x - weights[i]
+		if x < 0 {
+			return a1, c, nil
+		}
+	}
+	return a1, contenders[len(contenders) - 1], nil
+}
+
+/* getContenders returns all artworks a may meet */
+func getContenders(db Repository, a *database.Artwork, ex *Exclusions) ([]*database.Artwork, error) {
+	all, err := db.GetAllArtworks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error with contender list: %s\n", err)
+		return nil, err
+	}
+	below := ex.DuelsBelow(a)
+	var res []*database.Artwork
+	for _, c := range all {
+		if below > 0 && c.DuelCount >= below {
+			continue
+		}
+		if c.ID != a.ID && !ex.Artwork(c) && !ex.Pair(a.ID, c.ID) {
+			res = append(res, c)
+		}
+	}
+	if len(res) < 1 {
+		ex.noPartner(a.ID)
+		return nil, errNoPartner
+	}
+	return res, nil
+}
@@ -0,0 +1,71 @@
+package rating
+
+import (
+	"math"
+
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* Elo rating: the points scored by the winner (and paid for by the loser)
+   depend on the rating of the players.  Highly rated players can only gain
+   few points from winning against low rated players, but they can lose a
+   lot of points. */
+type Elo struct {
+	/* The K-factor can depend on the number of duels and the rating of
+	   each side, see Wikipedia on the Elo rating system and k-factor.
+	   KFactor applies if no step of the Schedule matches. */
+	KFactor		float64
+	Schedule	[]KStep
+}
+
+/* KStep gives artworks with fewer than MaxDuels duels and a rating
+   between MinRating and MaxRating the K-factor KFactor. Limits that
+   are 0 don't apply. */
+type KStep struct {
+	MaxDuels	uint64
+	MinRating	float64
+	MaxRating	float64
+	KFactor		float64
+}
+
+func eloFromConfiguration() *Elo {
+	e := &Elo{KFactor: config.RatingKFactor()}
+	for _, s := range config.RatingKSchedule() {
+		e.Schedule = append(e.Schedule, KStep(s))
+	}
+	return e
+}
+
+func (e *Elo) Name() string {
+	return "elo"
+}
+
+/* K returns the K-factor for a player, so new artworks can move fast
+   while established ones stay put. */
+func (e *Elo) K(p Player) float64 {
+	for _, s := range e.Schedule {
+		if s.MaxDuels != 0 && p.DuelCount >= s.MaxDuels {
+			continue
+		}
+		if s.MinRating != 0 && p.Rating < s.MinRating {
+			continue
+		}
+		if s.MaxRating != 0 && p.Rating > s.MaxRating {
+			continue
+		}
+		return s.KFactor
+	}
+	return e.KFactor
+}
+
+/* With different K-factors per side, the points won are no longer the
+   points lost. */
+func (e *Elo) Update(one, two Player, score float64) (Player, Player) {
+	expected_score_one := 1.0 / (1.0 + math.Pow(10, (two.Rating - one.Rating)/400.0))
+	diff_one := e.K(one) * (score - expected_score_one)
+	diff_two := e.K(two) * ((1.0 - score) - (1.0 - expected_score_one))
+
+	one.Rating = one.Rating + diff_one
+	two.Rating = two.Rating + diff_two
+	return one, two
+}
@@ -0,0 +1,81 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEloK(t *testing.T) {
+	e := &Elo{
+		KFactor: 16,
+		Schedule: []KStep{
+			{MaxDuels: 10, KFactor: 40},
+			{MinRating: 1000, MaxRating: 1200, KFactor: 24},
+			{MinRating: 1100, KFactor: 8},
+			{MaxRating: 600, KFactor: 32},
+		},
+	}
+	for _, tc := range []struct {
+		name	string
+		p	Player
+		want	float64
+	}{
+		{"new artwork", Player{Rating: 1100, DuelCount: 0}, 40},
+		{"below max duels", Player{Rating: 1100, DuelCount: 9}, 40},
+		{"max duels is exclusive", Player{Rating: 800, DuelCount: 10}, 16},
+		{"min rating is inclusive", Player{Rating: 1000, DuelCount: 10}, 24},
+		{"max rating is inclusive", Player{Rating: 1200, DuelCount: 10}, 24},
+		{"first matching step wins", Player{Rating: 1150, DuelCount: 10}, 24},
+		{"above the band", Player{Rating: 1200.5, DuelCount: 10}, 8},
+		{"below the band", Player{Rating: 999.5, DuelCount: 10}, 16},
+		{"only max rating", Player{Rating: 600, DuelCount: 10}, 32},
+		{"no step matches", Player{Rating: 800, DuelCount: 50}, 16},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := e.K(tc.p); got != tc.want {
+				t.Errorf("got K %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEloKWithoutSchedule(t *testing.T) {
+	e := &Elo{KFactor: 16}
+	if got := e.K(Player{Rating: 800}); got != 16 {
+		t.Errorf("got K %v, want 16", got)
+	}
+}
+
+/* Each side scores with its own K-factor, so a new artwork moves twice
+   as far as the established one it beat. */
+func TestEloUpdate(t *testing.T) {
+	e := &Elo{KFactor: 16, Schedule: []KStep{{MaxDuels: 10, KFactor: 32}}}
+	for _, tc := range []struct {
+		name		string
+		one		Player
+		two		Player
+		score		float64
+		wantOne		float64
+		wantTwo		float64
+	}{
+		{"even, both established", Player{Rating: 800, DuelCount: 20}, Player{Rating: 800, DuelCount: 20}, 1, 808, 792},
+		{"even, one new", Player{Rating: 800, DuelCount: 2}, Player{Rating: 800, DuelCount: 20}, 1, 816, 792},
+		{"even, two new", Player{Rating: 800, DuelCount: 20}, Player{Rating: 800, DuelCount: 2}, 1, 808, 784},
+		{"favourite wins", Player{Rating: 1000, DuelCount: 20}, Player{Rating: 800, DuelCount: 20}, 1, 1003.8440, 796.1560},
+		{"underdog wins", Player{Rating: 1000, DuelCount: 20}, Player{Rating: 800, DuelCount: 20}, 0, 987.8440, 812.1560},
+		{"draw", Player{Rating: 1000, DuelCount: 20}, Player{Rating: 800, DuelCount: 2}, 0.5, 995.8440, 808.3119},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			one, two := e.Update(tc.one, tc.two, tc.score)
+			if math.Abs(one.Rating - tc.wantOne) > 0.0001 {
+				t.Errorf("got %.4f for one, want %.4f", one.Rating, tc.wantOne)
+			}
+			if math.Abs(two.Rating - tc.wantTwo) > 0.0001 {
+				t.Errorf("got %.4f for two, want %.4f", two.Rating, tc.wantTwo)
+			}
+			if one.DuelCount != tc.one.DuelCount || two.DuelCount != tc.two.DuelCount {
+				t.Errorf("duel counts changed to %d and %d", one.DuelCount, two.DuelCount)
+			}
+		})
+	}
+}
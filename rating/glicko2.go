@@ -0,0 +1,97 @@
+package rating
+
+import (
+	"math"
+)
+
+/* Glicko-2, see Mark E. Glickman, "Example of the Glicko-2 system",
+   http://www.glicko.net/glicko/glicko2.pdf
+
+   Every duel is treated as a rating period of its own. New artworks start
+   with a high rating deviation, so their rating moves quickly until the
+   system is confident about it. */
+type Glicko2 struct {
+	/* constrains the change in volatility over time, reasonable
+	   values are between 0.3 and 1.2 */
+	Tau		float64
+}
+
+/* converts between the Glicko and the Glicko-2 scale */
+const glicko2Scale = 173.7178
+
+/* the rating scale is centered here. Only rating differences matter for
+   the result, so this doesn't need to match rating.default_points. */
+const glicko2Center = 1500.0
+
+/* convergence tolerance for the volatility iteration */
+const glicko2Epsilon = 0.000001
+
+func (g *Glicko2) Name() string {
+	return "glicko2"
+}
+
+func (g *Glicko2) Update(one, two Player, score float64) (Player, Player) {
+	return g.update(one, two, score), g.update(two, one, 1.0 - score)
+}
+
+/* update computes the new state of p after a single game against o with
+   the given score (step 2 to 8 of the paper). */
+func (g *Glicko2) update(p, o Player, score float64) Player {
+	mu := (p.Rating - glicko2Center) / glicko2Scale
+	phi := p.Deviation / glicko2Scale
+	sigma := p.Volatility
+	mu_o := (o.Rating - glicko2Center) / glicko2Scale
+	phi_o := o.Deviation / glicko2Scale
+
+	g_o := 1.0 / math.Sqrt(1.0 + 3.0 * phi_o * phi_o / (math.Pi * math.Pi))
+	e := 1.0 / (1.0 + math.Exp(-g_o * (mu - mu_o)))
+	v := 1.0 / (g_o * g_o * e * (1.0 - e))
+	delta := v * g_o * (score - e)
+
+	sigma = g.volatility(phi, sigma, v, delta)
+
+	phi_star := math.Sqrt(phi * phi + sigma * sigma)
+	phi = 1.0 / math.Sqrt(1.0 / (phi_star * phi_star) + 1.0 / v)
+	mu = mu + phi * phi * g_o * (score - e)
+
+	p.Rating = mu * glicko2Scale + glicko2Center
+	p.Deviation = phi * glicko2Scale
+	p.Volatility = sigma
+	return p
+}
+
+/* volatility finds the new volatility with the Illinois algorithm
+   (step 5 of the paper). */
+func (g *Glicko2) volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi * phi + v + ex
+		return ex * (delta * delta - phi * phi - v - ex) / (2.0 * d * d) - (x - a) / (g.Tau * g.Tau)
+	}
+
+	A := a
+	var B float64
+	if delta * delta > phi * phi + v {
+		B = math.Log(delta * delta - phi * phi - v)
+	} else {
+		k := 1.0
+		for f(a - k * g.Tau) < 0 {
+			k = k + 1
+		}
+		B = a - k * g.Tau
+	}
+	fA := f(A)
+	fB := f(B)
+	for math.Abs(B - A) > glicko2Epsilon {
+		C := A + (A - B) * fA / (fB - fA)
+		fC := f(C)
+		if fC * fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA = fA / 2.0
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2.0)
+}
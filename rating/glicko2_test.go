@@ -0,0 +1,65 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+/* The player and opponents of the example in Glickman's paper, with
+   tau 0.5, each game played as a rating period of its own. Taken
+   together the three games give 1464.06, 151.52 and 0.05999 as in the
+   paper, the single games were computed with the same formulas. */
+func TestGlicko2Update(t *testing.T) {
+	g := &Glicko2{Tau: 0.5}
+	player := Player{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	for _, tc := range []struct {
+		name		string
+		p		Player
+		o		Player
+		score		float64
+		want		Player
+	}{
+		{"win against 1400", player, Player{Rating: 1400, Deviation: 30, Volatility: 0.06}, 1,
+			Player{Rating: 1563.56, Deviation: 175.40, Volatility: 0.0599987}},
+		{"loss against 1550", player, Player{Rating: 1550, Deviation: 100, Volatility: 0.06}, 0,
+			Player{Rating: 1426.69, Deviation: 175.90, Volatility: 0.0599990}},
+		{"loss against 1700", player, Player{Rating: 1700, Deviation: 300, Volatility: 0.06}, 0,
+			Player{Rating: 1455.86, Deviation: 186.98, Volatility: 0.0599992}},
+		{"upset loss against 1400", player, Player{Rating: 1400, Deviation: 30, Volatility: 0.06}, 0,
+			Player{Rating: 1387.26, Deviation: 175.40, Volatility: 0.0600009}},
+		{"upset win against 1700", player, Player{Rating: 1700, Deviation: 300, Volatility: 0.06}, 1,
+			Player{Rating: 1601.62, Deviation: 186.98, Volatility: 0.0600013}},
+		{"opponent side of the first game", Player{Rating: 1400, Deviation: 30, Volatility: 0.06}, player, 0,
+			Player{Rating: 1398.14, Deviation: 31.67, Volatility: 0.0599991}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := g.update(tc.p, tc.o, tc.score)
+			if math.Abs(got.Rating - tc.want.Rating) > 0.01 {
+				t.Errorf("got rating %.4f, want %.2f", got.Rating, tc.want.Rating)
+			}
+			if math.Abs(got.Deviation - tc.want.Deviation) > 0.01 {
+				t.Errorf("got deviation %.4f, want %.2f", got.Deviation, tc.want.Deviation)
+			}
+			if math.Abs(got.Volatility - tc.want.Volatility) > 0.0000005 {
+				t.Errorf("got volatility %.8f, want %.7f", got.Volatility, tc.want.Volatility)
+			}
+		})
+	}
+}
+
+/* Update rates both sides, each against the other's old state. */
+func TestGlicko2UpdateBothSides(t *testing.T) {
+	g := &Glicko2{Tau: 0.5}
+	one := Player{Rating: 1500, Deviation: 200, Volatility: 0.06, DuelCount: 3}
+	two := Player{Rating: 1400, Deviation: 30, Volatility: 0.06, DuelCount: 7}
+	gotOne, gotTwo := g.Update(one, two, 1)
+	if gotOne != g.update(one, two, 1) {
+		t.Errorf("got %+v for one, want %+v", gotOne, g.update(one, two, 1))
+	}
+	if gotTwo != g.update(two, one, 0) {
+		t.Errorf("got %+v for two, want %+v", gotTwo, g.update(two, one, 0))
+	}
+	if gotOne.DuelCount != 3 || gotTwo.DuelCount != 7 {
+		t.Errorf("duel counts changed to %d and %d", gotOne.DuelCount, gotTwo.DuelCount)
+	}
+}
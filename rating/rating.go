@@ -0,0 +1,46 @@
+package rating
+
+import (
+	"fmt"
+
+	"github.com/tinx/proto-artbattle/internal/repository/config"
+)
+
+/* Player is the rating state of one artwork. Deviation and Volatility are
+   only used by Glicko-2. */
+type Player struct {
+	Rating		float64
+	Deviation	float64
+	Volatility	float64
+	DuelCount	uint64
+}
+
+/* Algorithm computes new ratings after a duel. score is the result from
+   the point of view of player one: 1 if one won, 0 if two won. */
+type Algorithm interface {
+	Name() string
+	Update(one, two Player, score float64) (Player, Player)
+}
+
+/* FromConfiguration returns the algorithm selected by rating.algorithm,
+   with its current settings. */
+func FromConfiguration() Algorithm {
+	a, err := ByName(config.RatingAlgorithm())
+	if err != nil {
+		/* can't happen, the configuration is validated */
+		return eloFromConfiguration()
+	}
+	return a
+}
+
+/* ByName returns the named algorithm with the configured settings. */
+func ByName(name string) (Algorithm, error) {
+	switch name {
+	case "elo":
+		return eloFromConfiguration(), nil
+	case "glicko2":
+		return &Glicko2{Tau: config.RatingGlicko2Tau()}, nil
+	default:
+		return nil, fmt.Errorf("unknown rating algorithm: %s", name)
+	}
+}